@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// ParseReason - причина ошибки разбора упакованной строки
+type ParseReason int
+
+const (
+	// ReasonLeadingDigit - число повторов без символа перед ним: "3a", "3ab"
+	ReasonLeadingDigit ParseReason = iota + 1
+	// ReasonDanglingBackslash - после обратного слэша нет цифры или слэша: "\\f", "a\\"
+	ReasonDanglingBackslash
+	// ReasonCountOverflow - число повторов не помещается в int (см. UpdateLen)
+	ReasonCountOverflow
+)
+
+func (r ParseReason) String() string {
+	switch r {
+	case ReasonLeadingDigit:
+		return "digit without a symbol to repeat"
+	case ReasonDanglingBackslash:
+		return "backslash must be followed by a digit or a backslash"
+	case ReasonCountOverflow:
+		return "repeat count is too large"
+	default:
+		return fmt.Sprintf("ParseReason(%d)", int(r))
+	}
+}
+
+// ParseError - ошибка в упакованной строке. Offset и RuneOffset - позиция (в байтах и символах)
+// символа, к которому относится ошибка
+type ParseError struct {
+	Offset     int
+	RuneOffset int
+	Reason     ParseReason
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("incorrect string at byte %d (rune %d): %s", e.Offset, e.RuneOffset, e.Reason)
+}
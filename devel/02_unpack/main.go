@@ -1,20 +1,43 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"strings"
-	"unicode"
+	"unicode/utf8"
 )
 
 const backslashCode = 92
 
+// Наибольшее число повторов: длина результата в байтах должна помещаться в int
+const maxCount = math.MaxInt / utf8.UTFMax
+
+// ErrOutputTooLong - результат распаковки длиннее MaxOutput (защита от строк вида a999999999)
+var ErrOutputTooLong = errors.New("unpacked output is too long")
+
 type Unpacker struct {
 	//контекст
 	Symbol    rune
 	Len       int
 	Backslash bool
 
-	builder strings.Builder
+	// MaxOutput - ограничение длины результата в байтах, 0 - без ограничения
+	MaxOutput int
+
+	// символ в контексте есть; сам Symbol может быть и нулевым
+	hasSymbol bool
+	// сколько байт уже распаковано
+	written int
+	// позиция текущего символа и последнего обратного слэша: байт и номер символа
+	offset, runeOffset      int
+	slashOffset, slashRunes int
+	builder                 strings.Builder
+	// emit получает символ с числом повторов вместо записи в builder (потоковая распаковка)
+	emit func(r rune, n int)
 }
 
 func NewUnpacker() *Unpacker {
@@ -24,7 +47,7 @@ func NewUnpacker() *Unpacker {
 }
 
 func (u *Unpacker) IsSymbolEmpty() bool {
-	return u.Symbol == rune(0)
+	return !u.hasSymbol
 }
 
 func (u *Unpacker) IsBackslash() bool {
@@ -33,6 +56,7 @@ func (u *Unpacker) IsBackslash() bool {
 
 func (u *Unpacker) UpdateSymbol(newChar rune) {
 	u.Symbol = newChar
+	u.hasSymbol = true
 }
 
 func (u *Unpacker) UpdateLen(newLen int) {
@@ -41,34 +65,68 @@ func (u *Unpacker) UpdateLen(newLen int) {
 
 func (u *Unpacker) UpdateBackslash() {
 	u.Backslash = true
+	u.slashOffset, u.slashRunes = u.offset, u.runeOffset
+}
+
+// Reset готовит Unpacker к разбору новой строки, в том числе после ошибки
+func (u *Unpacker) Reset() {
+	u.ResetCtx()
+	u.builder.Reset()
+	u.written = 0
+	u.offset, u.runeOffset = 0, 0
+}
+
+// errorAt - ошибка разбора в текущем символе
+func (u *Unpacker) errorAt(reason ParseReason) error {
+	return &ParseError{Offset: u.offset, RuneOffset: u.runeOffset, Reason: reason}
+}
+
+// danglingBackslash - ошибка указывает на сам неиспользованный слэш
+func (u *Unpacker) danglingBackslash() error {
+	return &ParseError{Offset: u.slashOffset, RuneOffset: u.slashRunes, Reason: ReasonDanglingBackslash}
 }
 
 func (u *Unpacker) ResetCtx() {
 	u.Symbol = rune(0)
 	u.Len = 0
 	u.Backslash = false
+	u.hasSymbol = false
 }
 
 // записывает контекст в итоговую строку указанное количество раз
-func (u *Unpacker) WriteCtxToString() {
+func (u *Unpacker) WriteCtxToString() error {
 	var len int
 	if u.Len == 0 {
 		len = 1
 	} else {
 		len = u.Len
 	}
-	u.builder.WriteString(strings.Repeat(string(u.Symbol), len))
+	// размер проверяется до записи, чтобы не выделять память под слишком длинную строку
+	size := len * utf8.RuneLen(u.Symbol)
+	if u.MaxOutput > 0 && u.written+size > u.MaxOutput {
+		return ErrOutputTooLong
+	}
+	u.written += size
+
+	if u.emit != nil {
+		u.emit(u.Symbol, len)
+	} else {
+		u.builder.WriteString(strings.Repeat(string(u.Symbol), len))
+	}
 	// очистка контекста после записи
 	u.ResetCtx()
+	return nil
 }
 
 func (u *Unpacker) SymbolsHandler(r rune) error {
 	// встретили новый символ буквы. Если контекст не пуст - надо записать данные в итоговую строку
 	// После обработки записываем в контекст новый символ
 	if !u.IsSymbolEmpty() {
-		u.WriteCtxToString()
+		if err := u.WriteCtxToString(); err != nil {
+			return err
+		}
 	} else if u.IsBackslash() {
-		return fmt.Errorf("incorrect string: unused backslash")
+		return u.danglingBackslash()
 	}
 	u.UpdateSymbol(r)
 	return nil
@@ -82,10 +140,18 @@ func (u *Unpacker) DigitsHandler(len rune) error {
 			u.UpdateSymbol(len)
 			return nil
 		}
-		return fmt.Errorf("incorrect string")
+		return u.errorAt(ReasonLeadingDigit)
 	}
 	// встретили очередную цифру в строке - обновляем длину (это длина символа, который сейчас в контексте)
-	u.UpdateLen(int(len - '0'))
+	digit := int(len - '0')
+	if u.Len > (maxCount-digit)/10 {
+		return u.errorAt(ReasonCountOverflow)
+	}
+	u.UpdateLen(digit)
+	// с ограничением длина не успевает переполнить int
+	if u.MaxOutput > 0 && u.Len > u.MaxOutput {
+		return ErrOutputTooLong
+	}
 	return nil
 }
 
@@ -97,53 +163,124 @@ func (u *Unpacker) BackslashHandler() error {
 			u.UpdateSymbol(rune(backslashCode))
 			return nil
 		}
-		u.WriteCtxToString()
+		if err := u.WriteCtxToString(); err != nil {
+			return err
+		}
 		u.UpdateBackslash()
 		return nil
 	}
 
 	if !u.IsSymbolEmpty() {
-		u.WriteCtxToString()
+		if err := u.WriteCtxToString(); err != nil {
+			return err
+		}
 	}
 	u.UpdateBackslash()
 	return nil
 }
 
-func (u *Unpacker) Do(str string) (string, error) {
-	for _, v := range str {
-		if unicode.IsDigit(v) {
-			// обработчик, когда встречаем число
-			err := u.DigitsHandler(v)
-			if err != nil {
-				return "", err
-			}
-			continue
-		} else if int(v) == backslashCode {
-			// обработчик после слэша
-			err := u.BackslashHandler()
-			if err != nil {
-				return "", err
-			}
-			continue
-		}
+// HandleRune обрабатывает очередной символ упакованной строки; size - его длина во входе в байтах
+func (u *Unpacker) HandleRune(v rune, size int) error {
+	defer func() {
+		u.offset += size
+		u.runeOffset++
+	}()
 
-		err := u.SymbolsHandler(v)
-		if err != nil {
-			return "", err
-		}
+	if isDigit(v) {
+		// обработчик, когда встречаем число
+		return u.DigitsHandler(v)
+	} else if int(v) == backslashCode {
+		// обработчик после слэша
+		return u.BackslashHandler()
 	}
+	return u.SymbolsHandler(v)
+}
+
+// isDigit - только ASCII-цифры задают число повторов. Цифры других письменностей ("٣")
+// считаются обычными символами: счётчик собирается как len - '0'
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
 
-	//обработка накопленного контекста после завершения строки
+// Finish обрабатывает накопленный контекст после завершения строки
+func (u *Unpacker) Finish() error {
 	if !u.IsSymbolEmpty() {
-		u.WriteCtxToString()
+		return u.WriteCtxToString()
 	} else if u.Backslash {
-		return "", fmt.Errorf("incorrect string: unused backslash")
+		return u.danglingBackslash()
 	}
+	return nil
+}
 
-	fmt.Println(u.builder.String())
+// Do распаковывает строку. Ошибки в строке возвращаются как *ParseError;
+// один Unpacker можно использовать повторно, в том числе после ошибки
+func (u *Unpacker) Do(str string) (string, error) {
+	u.Reset()
+	for i := 0; i < len(str); {
+		v, size := utf8.DecodeRuneInString(str[i:])
+		if err := u.HandleRune(v, size); err != nil {
+			return "", err
+		}
+		i += size
+	}
+
+	if err := u.Finish(); err != nil {
+		return "", err
+	}
 	return u.builder.String(), nil
 }
 
+// Ограничение распакованного вывода по умолчанию в CLI
+const defaultMaxOutput = 1 << 30
+
 func main() {
+	// пример: echo a4bc2d5e | go run . unpack; go run . pack file.txt
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run выполняет подкоманду pack или unpack. Вход - файл из аргументов или stdin
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: unpack <pack|unpack> [-max BYTES] [FILE]")
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	var maxOutput int
+	if args[0] == "unpack" {
+		flags.IntVar(&maxOutput, "max", defaultMaxOutput, "Maximum unpacked output length in bytes (0 - unlimited)")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
 
+	in := stdin
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	default:
+		return fmt.Errorf("%s: expected at most one file", args[0])
+	}
+
+	switch args[0] {
+	case "pack":
+		w := NewWriter(stdout)
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	case "unpack":
+		_, err := io.Copy(stdout, NewReader(in, maxOutput))
+		return err
+	default:
+		return fmt.Errorf("unknown command %q, expected pack or unpack", args[0])
+	}
 }
@@ -1,115 +1,140 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"testing/quick"
+)
 
-func Test_unpacking(t *testing.T) {
-	type args struct {
-		str string
-	}
-	tests := []struct {
+type unpackArgs struct {
+	str string
+}
+
+// unpackingTests - общая таблица для Test_unpacking и затравка для FuzzUnpacker
+func unpackingTests() []struct {
+	name     string
+	unpacker *Unpacker
+	args     unpackArgs
+	want     string
+	wantErr  bool
+} {
+	return []struct {
 		name     string
 		unpacker *Unpacker
-		args     args
+		args     unpackArgs
 		want     string
 		wantErr  bool
 	}{
 		{
 			name:     "OK - Default",
 			unpacker: NewUnpacker(),
-			args:     args{str: "a4bc2d5e"},
+			args:     unpackArgs{str: "a4bc2d5e"},
 			want:     "aaaabccddddde",
 		},
 		{
 			name:     "Ok - long numbers",
 			unpacker: NewUnpacker(),
-			args:     args{str: "a100b50"},
+			args:     unpackArgs{str: "a100b50"},
 			want:     "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
 		},
 		{
 			name:     "Ok - No repeat",
 			unpacker: NewUnpacker(),
-			args:     args{str: "abcd"},
+			args:     unpackArgs{str: "abcd"},
 			want:     "abcd",
 		},
 		{
 			name:     "Ok - with unicode",
 			unpacker: NewUnpacker(),
-			args:     args{str: "a5한3b2"},
+			args:     unpackArgs{str: "a5한3b2"},
 			want:     "aaaaa한한한bb",
 		},
+		{
+			name:     "Ok - non-ASCII digits are symbols",
+			unpacker: NewUnpacker(),
+			args:     unpackArgs{str: "a٣b2٣"},
+			want:     "a٣bb٣",
+		},
 		{
 			name:     "Empty string",
 			unpacker: NewUnpacker(),
-			args:     args{str: ""},
+			args:     unpackArgs{str: ""},
 			want:     "",
 		},
 		{
 			name:     "Error string - only numbers",
 			unpacker: NewUnpacker(),
-			args:     args{str: "123"},
+			args:     unpackArgs{str: "123"},
 			want:     "",
 			wantErr:  true,
 		},
 		{
 			name:     "Error string - first number",
 			unpacker: NewUnpacker(),
-			args:     args{str: "12ab5d5"},
+			args:     unpackArgs{str: "12ab5d5"},
 			want:     "",
 			wantErr:  true,
 		},
 		{
 			name:     "non-number or word chars",
 			unpacker: NewUnpacker(),
-			args:     args{str: "a5d5f2.3d"},
+			args:     unpackArgs{str: "a5d5f2.3d"},
 			want:     "aaaaadddddff...d",
 		},
 		{
 			name:     "Escape - 1",
 			unpacker: NewUnpacker(),
-			args:     args{str: `qwe\4\5`},
+			args:     unpackArgs{str: `qwe\4\5`},
 			want:     "qwe45",
 		},
 		{
 			name:     "Escape - 2",
 			unpacker: NewUnpacker(),
-			args:     args{str: `qwe\45`},
+			args:     unpackArgs{str: `qwe\45`},
 			want:     "qwe44444",
 		},
 		{
 			name:     "Escape - 3",
 			unpacker: NewUnpacker(),
-			args:     args{str: `qwe\\5`},
+			args:     unpackArgs{str: `qwe\\5`},
 			want:     `qwe\\\\\`,
 		},
 		{
 			name:     "Escape - 4",
 			unpacker: NewUnpacker(),
-			args:     args{str: `qwe\`},
+			args:     unpackArgs{str: `qwe\`},
 			want:     "",
 			wantErr:  true,
 		},
 		{
 			name:     "Escape - 5",
 			unpacker: NewUnpacker(),
-			args:     args{str: `\\\`},
+			args:     unpackArgs{str: `\\\`},
 			want:     "",
 			wantErr:  true,
 		},
 		{
 			name:     "Escape - 6",
 			unpacker: NewUnpacker(),
-			args:     args{str: `\\\\a`},
+			args:     unpackArgs{str: `\\\\a`},
 			want:     `\\a`,
 		},
 		{
 			name:     "Escape - 7",
 			unpacker: NewUnpacker(),
-			args:     args{str: `\f`},
+			args:     unpackArgs{str: `\f`},
 			want:     "",
 			wantErr:  true,
 		},
 	}
-	for _, tt := range tests {
+}
+
+func Test_unpacking(t *testing.T) {
+	for _, tt := range unpackingTests() {
 		t.Run(tt.name, func(t *testing.T) {
 			got, err := tt.unpacker.Do(tt.args.str)
 			if (err != nil) != tt.wantErr {
@@ -122,3 +147,226 @@ func Test_unpacking(t *testing.T) {
 		})
 	}
 }
+
+func Test_Pack(t *testing.T) {
+	tests := []struct {
+		str  string
+		want string
+	}{
+		{str: "aaaabccddddde", want: "a4bc2d5e"},
+		{str: "", want: ""},
+		{str: "aaaaa한한한bb", want: "a5한3b2"},
+		{str: "qwe45", want: `qwe\4\5`},
+		{str: "qwe44444", want: `qwe\45`},
+		{str: `qwe\\\\\`, want: `qwe\\5`},
+		{str: strings.Repeat("x", 12) + "1", want: `x12\1`},
+		{str: "a\x00\x00", want: "a\x002"},
+		{str: "٣٣x", want: "٣2x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			if got := Pack(tt.str); got != tt.want {
+				t.Errorf("Pack() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// runsString строит строку с длинными сериями: случайные строки из testing/quick их почти не содержат
+func runsString(parts []string, counts []uint8) string {
+	var b strings.Builder
+	for i, part := range parts {
+		n := 1
+		if i < len(counts) {
+			n = int(counts[i]%20) + 1
+		}
+		for _, r := range part {
+			b.WriteString(strings.Repeat(string(r), n))
+		}
+	}
+	return b.String()
+}
+
+func Test_Pack_roundTrip(t *testing.T) {
+	property := func(parts []string, counts []uint8) bool {
+		s := runsString(parts, counts)
+		got, err := NewUnpacker().Do(Pack(s))
+		return err == nil && got == s
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_stream_roundTrip(t *testing.T) {
+	property := func(parts []string, counts []uint8) bool {
+		s := runsString(parts, counts)
+
+		// запись по одному байту режет и серии, и символы UTF-8
+		packed := &bytes.Buffer{}
+		w := NewWriter(packed)
+		for i := 0; i < len(s); i++ {
+			w.Write([]byte{s[i]})
+		}
+		if w.Close() != nil || packed.String() != Pack(s) {
+			return false
+		}
+
+		got, err := io.ReadAll(iotest.OneByteReader(NewReader(packed, 0)))
+		return err == nil && string(got) == s
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Reader_sameAsDo(t *testing.T) {
+	for _, str := range []string{"a4bc2d5e", "a100b50", "a5한3b2", "", "123", `qwe\45`, `qwe\\5`, `qwe\`, `\f`, "a\x003"} {
+		want, wantErr := NewUnpacker().Do(str)
+		got, err := io.ReadAll(NewReader(strings.NewReader(str), 0))
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("%q: Reader error = %v, Do error = %v", str, err, wantErr)
+			continue
+		}
+		if err == nil && string(got) != want {
+			t.Errorf("%q: Reader = %q, Do = %q", str, got, want)
+		}
+	}
+}
+
+func Test_MaxOutput(t *testing.T) {
+	u := NewUnpacker()
+	u.MaxOutput = 1000
+	if _, err := u.Do("a999999999"); !errors.Is(err, ErrOutputTooLong) {
+		t.Errorf("Do() error = %v, want ErrOutputTooLong", err)
+	}
+
+	u = NewUnpacker()
+	u.MaxOutput = 10
+	if got, err := u.Do("a5b5"); err != nil || got != "aaaaabbbbb" {
+		t.Errorf("Do() = %q, %v, want output of exactly MaxOutput bytes", got, err)
+	}
+
+	if _, err := io.ReadAll(NewReader(strings.NewReader("ab999999999999999999999"), 1<<20)); !errors.Is(err, ErrOutputTooLong) {
+		t.Errorf("Reader error = %v, want ErrOutputTooLong", err)
+	}
+}
+
+func Test_run(t *testing.T) {
+	tests := []struct {
+		args    []string
+		stdin   string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"unpack"}, stdin: "a4bc2d5e\n", want: "aaaabccddddde\n"},
+		{args: []string{"pack"}, stdin: "aaaabccddddde\n", want: "a4bc2d5e\n"},
+		{args: []string{"unpack", "-max", "5"}, stdin: "a6", wantErr: true},
+		{args: []string{"unpack"}, stdin: "3a", wantErr: true},
+		{args: []string{"zip"}, wantErr: true},
+		{args: []string{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := run(tt.args, strings.NewReader(tt.stdin), out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && out.String() != tt.want {
+				t.Errorf("run() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseError(t *testing.T) {
+	tests := []struct {
+		str        string
+		offset     int
+		runeOffset int
+		reason     ParseReason
+	}{
+		{str: "3a", offset: 0, runeOffset: 0, reason: ReasonLeadingDigit},
+		{str: "한한\\", offset: 6, runeOffset: 2, reason: ReasonDanglingBackslash},
+		{str: "한\\f", offset: 3, runeOffset: 1, reason: ReasonDanglingBackslash},
+		{str: `\\\`, offset: 2, runeOffset: 2, reason: ReasonDanglingBackslash},
+		{str: "ab\\\\\\", offset: 4, runeOffset: 4, reason: ReasonDanglingBackslash},
+		{str: "한" + strings.Repeat("9", 30), offset: 3 + 18, runeOffset: 1 + 18, reason: ReasonCountOverflow},
+	}
+	for _, tt := range tests {
+		_, err := NewUnpacker().Do(tt.str)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("Do(%q) error = %v, want *ParseError", tt.str, err)
+			continue
+		}
+		want := ParseError{Offset: tt.offset, RuneOffset: tt.runeOffset, Reason: tt.reason}
+		if *perr != want {
+			t.Errorf("Do(%q) error = %+v, want %+v", tt.str, *perr, want)
+		}
+
+		_, err = io.ReadAll(NewReader(iotest.OneByteReader(strings.NewReader(tt.str)), 0))
+		if !errors.As(err, &perr) || *perr != want {
+			t.Errorf("Reader(%q) error = %v, want %v", tt.str, err, &want)
+		}
+	}
+}
+
+func Test_Unpacker_reuse(t *testing.T) {
+	u := NewUnpacker()
+	for _, tt := range []struct {
+		str     string
+		want    string
+		wantErr bool
+	}{
+		{str: "a3", want: "aaa"},
+		{str: "b2", want: "bb"},
+		{str: `qwe\`, wantErr: true},
+		{str: "5", wantErr: true},
+		{str: "c", want: "c"},
+		{str: `a\`, wantErr: true},
+		{str: "2d", wantErr: true},
+		{str: "x2", want: "xx"},
+	} {
+		got, err := u.Do(tt.str)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Do(%q) = %q, %v, want %q (error %v)", tt.str, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func FuzzUnpacker(f *testing.F) {
+	for _, tt := range unpackingTests() {
+		f.Add(tt.args.str)
+	}
+
+	u := NewUnpacker()
+	u.MaxOutput = 1 << 16
+	f.Fuzz(func(t *testing.T, s string) {
+		got, err := u.Do(s)
+
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			if perr.Offset < 0 || perr.Offset >= len(s) || perr.RuneOffset < 0 || perr.RuneOffset > perr.Offset {
+				t.Fatalf("Do(%q) error %+v is out of bounds", s, *perr)
+			}
+		} else if err != nil && !errors.Is(err, ErrOutputTooLong) {
+			t.Fatalf("Do(%q) unexpected error %v", s, err)
+		}
+
+		streamed, serr := io.ReadAll(NewReader(strings.NewReader(s), u.MaxOutput))
+		if (err == nil) != (serr == nil) {
+			t.Fatalf("Do(%q) error = %v, Reader error = %v", s, err, serr)
+		}
+		if err != nil {
+			return
+		}
+		if string(streamed) != got {
+			t.Fatalf("Reader(%q) = %q, Do() = %q", s, streamed, got)
+		}
+		if again, err := NewUnpacker().Do(Pack(got)); err != nil || again != got {
+			t.Fatalf("Do(Pack(%q)) = %q, %v", got, again, err)
+		}
+	})
+}
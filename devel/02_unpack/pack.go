@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pack - обратное к Unpacker.Do преобразование: серии одинаковых символов заменяются
+// символом и числом повторов, цифры и обратный слэш экранируются. Для любой строки в UTF-8
+// распаковка Pack(s) возвращает s
+func Pack(s string) string {
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.String()
+}
+
+// Writer упаковывает записываемые в него данные и пишет результат в dst.
+// Серия может продолжаться между вызовами Write, поэтому в конце нужен Close
+type Writer struct {
+	dst *bufio.Writer
+
+	run   rune
+	count int
+	// начало символа UTF-8, разрезанного между вызовами Write
+	partial []byte
+}
+
+func NewWriter(dst io.Writer) *Writer {
+	return &Writer{dst: bufio.NewWriter(dst)}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	data := p
+	if len(w.partial) > 0 {
+		data = append(w.partial, p...)
+		w.partial = nil
+	}
+
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			w.partial = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		w.add(r)
+		data = data[size:]
+	}
+	return len(p), nil
+}
+
+// Close дописывает последнюю серию. Сам dst не закрывается
+func (w *Writer) Close() error {
+	// незавершённый символ в конце - некорректный UTF-8, он кодируется как U+FFFD
+	for _, r := range string(w.partial) {
+		w.add(r)
+	}
+	w.partial = nil
+	w.writeRun()
+	w.count = 0
+	return w.dst.Flush()
+}
+
+func (w *Writer) add(r rune) {
+	if w.count > 0 && r == w.run {
+		w.count++
+		return
+	}
+	w.writeRun()
+	w.run, w.count = r, 1
+}
+
+func (w *Writer) writeRun() {
+	if w.count == 0 {
+		return
+	}
+	if isDigit(w.run) || w.run == backslashCode {
+		w.dst.WriteByte(backslashCode)
+	}
+	w.dst.WriteRune(w.run)
+	if w.count > 1 {
+		w.dst.WriteString(strconv.Itoa(w.count))
+	}
+}
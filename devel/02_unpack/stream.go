@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// Reader распаковывает данные из src по мере чтения. Повторы не разворачиваются в памяти,
+// поэтому распакованный результат может быть сколь угодно большим (в пределах MaxOutput)
+type Reader struct {
+	src      *bufio.Reader
+	unpacker *Unpacker
+
+	// текущая серия: символ и сколько раз его ещё нужно выдать
+	run  rune
+	left int
+	// байты символа, не поместившиеся в предыдущий Read
+	pending []byte
+	encoded [utf8.UTFMax]byte
+	err     error
+}
+
+// NewReader возвращает Reader; maxOutput - ограничение длины результата в байтах, 0 - без ограничения
+func NewReader(src io.Reader, maxOutput int) *Reader {
+	r := &Reader{src: bufio.NewReader(src), unpacker: NewUnpacker()}
+	r.unpacker.MaxOutput = maxOutput
+	r.unpacker.emit = func(symbol rune, n int) {
+		r.run, r.left = symbol, n
+	}
+	return r
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		switch {
+		case len(r.pending) > 0:
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+		case r.left > 0 && r.run < utf8.RuneSelf:
+			// однобайтовые символы копируются сразу пачкой
+			c := min(r.left, len(p)-n)
+			for i := n; i < n+c; i++ {
+				p[i] = byte(r.run)
+			}
+			r.left -= c
+			n += c
+		case r.left > 0:
+			size := utf8.EncodeRune(r.encoded[:], r.run)
+			r.pending = r.encoded[:size]
+			r.left--
+		case r.err != nil:
+			if n > 0 {
+				return n, nil
+			}
+			return 0, r.err
+		default:
+			r.err = r.step()
+		}
+	}
+	return n, nil
+}
+
+// step передаёт распаковщику следующий символ src, в конце src - завершает распаковку
+func (r *Reader) step() error {
+	v, size, err := r.src.ReadRune()
+	if errors.Is(err, io.EOF) {
+		if err := r.unpacker.Finish(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	return r.unpacker.HandleRune(v, size)
+}
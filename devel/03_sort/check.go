@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// disorder - первая строка, нарушающая порядок сортировки (-c / -C)
+type disorder struct {
+	filename string
+	num      int
+	line     string
+}
+
+func (d *disorder) Error() string {
+	return fmt.Sprintf("%s:%d: disorder: %s", d.filename, d.num, d.line)
+}
+
+// checkSorted проверяет, что файл уже отсортирован, и возвращает *disorder для первой строки
+// не на своём месте. С -u строки с равными ключами тоже считаются нарушением порядка
+func checkSorted(filename string, params parameters) error {
+	c := newComparator(params)
+	var prev string
+	num := 0
+	return readFileLines(filename, func(line string) error {
+		num++
+		if num > 1 {
+			var inOrder bool
+			if params.isUnique {
+				inOrder = c.compareKeys(prev, line) < 0
+			} else {
+				inOrder = c.compare(prev, line) <= 0
+			}
+			if !inOrder {
+				return &disorder{filename: filename, num: num, line: line}
+			}
+		}
+		prev = line
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collation сравнивает строки по правилам Unicode CLDR для языка из --locale.
+// collate.Collator нельзя использовать из нескольких горутин, поэтому они берутся из пула
+type collation struct {
+	plain  sync.Pool
+	folded sync.Pool
+}
+
+// newCollation возвращает nil для пустой локали и для C/POSIX - тогда строки сравниваются побайтово
+func newCollation(locale string) (*collation, error) {
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return nil, nil
+	}
+	tag, err := parseLocale(locale)
+	if err != nil {
+		return nil, fmt.Errorf("bad locale %q: %w", locale, err)
+	}
+	return &collation{
+		plain:  sync.Pool{New: func() any { return collate.New(tag) }},
+		folded: sync.Pool{New: func() any { return collate.New(tag, collate.IgnoreCase) }},
+	}, nil
+}
+
+// parseLocale принимает как теги BCP 47 (ru-RU), так и имена локалей POSIX (ru_RU.UTF-8)
+func parseLocale(locale string) (language.Tag, error) {
+	name, _, _ := strings.Cut(locale, ".")
+	name, _, _ = strings.Cut(name, "@")
+	return language.Parse(strings.ReplaceAll(name, "_", "-"))
+}
+
+func (c *collation) compare(lhs, rhs string, foldCase bool) int {
+	pool := &c.plain
+	if foldCase {
+		pool = &c.folded
+	}
+	collator := pool.Get().(*collate.Collator)
+	defer pool.Put(collator)
+	return collator.CompareString(lhs, rhs)
+}
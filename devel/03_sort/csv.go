@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// readCSV читает записи CSV/TSV из всех файлов. Первая запись каждого файла - заголовок,
+// у всех файлов он должен совпадать
+func readCSV(filenames []string, comma rune) (header []string, records [][]string, err error) {
+	for _, name := range filenames {
+		fileHeader, fileRecords, err := readCSVFile(name, comma)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Пустые файлы заголовка не имеют и не участвуют в сравнении
+		if header != nil && fileHeader != nil && !slices.Equal(header, fileHeader) {
+			return nil, nil, fmt.Errorf("%s: header %q differs from %q", name, fileHeader, header)
+		}
+		if header == nil {
+			header = fileHeader
+		}
+		records = append(records, fileRecords...)
+	}
+	return header, records, nil
+}
+
+func readCSVFile(name string, comma rune) (header []string, records [][]string, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = comma
+	// число полей в записях не проверяется, недостающие поля считаются пустыми
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return header, records, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if header == nil {
+			header = record
+			continue
+		}
+		records = append(records, record)
+	}
+}
+
+func writeCSV(w io.Writer, comma rune, header []string, records [][]string) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	if header != nil {
+		writer.Write(header)
+	}
+	writer.WriteAll(records)
+	return writer.Error()
+}
+
+// resolveKeys заменяет имена колонок в ключах на их номера по заголовку
+func resolveKeys(keys []keySpec, header []string) ([]keySpec, error) {
+	column := func(name string) (int, error) {
+		i := slices.Index(header, name)
+		if i < 0 {
+			return 0, fmt.Errorf("unknown column %q", name)
+		}
+		return i + 1, nil
+	}
+
+	resolved := slices.Clone(keys)
+	for i := range resolved {
+		key := &resolved[i]
+		var err error
+		if key.startName != "" {
+			if key.startField, err = column(key.startName); err != nil {
+				return nil, err
+			}
+		}
+		if key.endName != "" {
+			if key.endField, err = column(key.endName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// doSortCSV сортирует записи CSV так же, как doSort строки
+func doSortCSV(records [][]string, params parameters) [][]string {
+	c := newComparator(params)
+	jobs := min(params.parallel, len(records)/minParallelLines)
+	records = parallelSort(records, jobs, func(lhs, rhs []string) bool {
+		return c.compareRecords(lhs, rhs) < 0
+	}, c.stable)
+	if params.isUnique {
+		records = uniqueSorted(records, func(lhs, rhs []string) bool { return c.compareRecordKeys(lhs, rhs) == 0 })
+	}
+	return records
+}
+
+// sortCSVFiles - режим --csv/--tsv: заголовок остаётся первой строкой, ключи могут ссылаться на имена колонок
+func sortCSVFiles(w io.Writer, params parameters) error {
+	header, records, err := readCSV(params.filenames, params.separator)
+	if err != nil {
+		return err
+	}
+	if params.keys, err = resolveKeys(params.keys, header); err != nil {
+		return err
+	}
+	return writeCSV(w, params.separator, header, doSortCSV(records, params))
+}
+
+func (c *comparator) compareRecordKeys(lhs, rhs []string) int {
+	for _, key := range c.keys {
+		if res := c.compareKey(c.extractRecord(lhs, key), c.extractRecord(rhs, key), key); res != 0 {
+			return res
+		}
+	}
+	return 0
+}
+
+// compareRecords при равных ключах сравнивает записи по полям, как строки целиком
+func (c *comparator) compareRecords(lhs, rhs []string) int {
+	if res := c.compareRecordKeys(lhs, rhs); res != 0 || c.stable {
+		return res
+	}
+	for i := 0; i < min(len(lhs), len(rhs)); i++ {
+		if res := c.compareWhole(lhs[i], rhs[i]); res != 0 {
+			return res
+		}
+	}
+	res := compareInts(len(lhs), len(rhs))
+	if c.reverse {
+		res = -res
+	}
+	return res
+}
+
+// extractRecord - ключ записи CSV. Поля уже разобраны, ключ из нескольких полей
+// склеивается через разделитель, смещения символов считаются как в строке
+func (c *comparator) extractRecord(record []string, key keySpec) string {
+	last := len(record)
+	if key.endField != 0 {
+		last = min(key.endField, len(record))
+	}
+	if key.startField > last {
+		return ""
+	}
+	line := strings.Join(record[key.startField-1:last], string(c.separator))
+
+	begin := 0
+	if key.options.skipStartBlanks {
+		begin = skipBlanks(line, begin)
+	}
+	begin = skipChars(line, begin, key.startChar-1)
+
+	end := len(line)
+	if key.endChar != 0 && key.endField <= len(record) {
+		end -= len(record[last-1])
+		if key.options.skipEndBlanks {
+			end = skipBlanks(line, end)
+		}
+		end = skipChars(line, end, key.endChar)
+	}
+
+	if end <= begin {
+		return ""
+	}
+	return line[begin:end]
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Примерные накладные расходы на одну строку в памяти: заголовок строки и место в срезе
+const lineOverhead = 32
+
+// parseSize разбирает размер буфера для -S. Как в GNU sort, число без суффикса - в килобайтах
+func parseSize(value string) (int64, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+
+	multipliers := map[byte]int64{'b': 1, 'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	multiplier := int64(1 << 10)
+	if m, ok := multipliers[value[len(value)-1]]; ok {
+		multiplier = m
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("bad buffer size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// readLines построчно читает файлы и передаёт строки в fn, не загружая файлы целиком
+func readLines(filenames []string, fn func(string) error) error {
+	for _, name := range filenames {
+		if err := readFileLines(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFileLines(name string, fn func(string) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine читает строку без завершающего перевода строки, длина строки не ограничена
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// mergeFanIn - сколько runs сливается за один проход. Если runs больше, они сливаются
+// в несколько проходов через промежуточные runs, чтобы не открывать все файлы сразу
+const mergeFanIn = 128
+
+// externalSorter сортирует данные больше доступной памяти: когда накопленные строки
+// превышают бюджет -S, они сортируются и сбрасываются во временный файл (run),
+// а в конце runs сливаются через кучу, не больше fanIn за раз
+type externalSorter struct {
+	params parameters
+	fanIn  int
+
+	chunk     []string
+	chunkSize int64
+
+	runs []string
+
+	// mu защищает dir и closed: cleanup вызывается и из обработчика сигнала
+	mu     sync.Mutex
+	dir    string
+	closed bool
+}
+
+func newExternalSorter(params parameters) *externalSorter {
+	return &externalSorter{params: params, fanIn: mergeFanIn}
+}
+
+func (s *externalSorter) add(line string) error {
+	s.chunk = append(s.chunk, line)
+	s.chunkSize += int64(len(line)) + lineOverhead
+	if s.params.bufferSize > 0 && s.chunkSize >= s.params.bufferSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// createRun создаёт новый временный файл. После cleanup файлы больше не создаются
+func (s *externalSorter) createRun() (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("sorter is cleaned up")
+	}
+	if s.dir == "" {
+		dir, err := os.MkdirTemp(s.params.tmpDir, "sort-")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	return os.CreateTemp(s.dir, "run-")
+}
+
+// spill сортирует накопленные строки и записывает их в новый временный файл
+func (s *externalSorter) spill() error {
+	file, err := s.createRun()
+	if err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	defer file.Close()
+
+	if err := writeLines(file, doSort(s.chunk, s.params)); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+
+	s.runs = append(s.runs, file.Name())
+	s.chunk = nil
+	s.chunkSize = 0
+	return nil
+}
+
+// output печатает отсортированный результат. Если ничего не сбрасывалось на диск,
+// сортировка выполняется целиком в памяти
+func (s *externalSorter) output(w io.Writer) error {
+	if len(s.runs) == 0 {
+		return writeLines(w, doSort(s.chunk, s.params))
+	}
+	if len(s.chunk) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge(w, s.runs, true)
+}
+
+// merge сливает отсортированные файлы в w. Пока файлов больше fanIn, соседние группы по fanIn
+// сливаются в промежуточные runs. owned - файлы принадлежат сортировщику и удаляются после слияния,
+// иначе это входные файлы -m, и они не трогаются
+func (s *externalSorter) merge(w io.Writer, names []string, owned bool) error {
+	for len(names) > s.fanIn {
+		var merged []string
+		// группа заменяется своим результатом на том же месте, поэтому равные строки
+		// по-прежнему идут в порядке входа
+		for i := 0; i < len(names); i += s.fanIn {
+			group := names[i:min(i+s.fanIn, len(names))]
+			name, err := s.mergeRun(group)
+			if err != nil {
+				return err
+			}
+			merged = append(merged, name)
+			if owned {
+				for _, name := range group {
+					os.Remove(name)
+				}
+			}
+		}
+		names, owned = merged, true
+	}
+	return mergeFiles(w, names, s.params)
+}
+
+// mergeRun сливает файлы в новый run и возвращает его имя
+func (s *externalSorter) mergeRun(names []string) (string, error) {
+	file, err := s.createRun()
+	if err != nil {
+		return "", fmt.Errorf("merge: %w", err)
+	}
+	defer file.Close()
+
+	if err := mergeFiles(file, names, s.params); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("merge: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// cleanup удаляет временные файлы. Безопасно вызывать несколько раз и из обработчика сигнала
+func (s *externalSorter) cleanup() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	writer := bufio.NewWriter(w)
+	for _, line := range lines {
+		writer.WriteString(line)
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+// runReader - текущая строка одного отсортированного файла при слиянии
+type runReader struct {
+	reader *bufio.Reader
+	line   string
+	index  int
+}
+
+// runHeap - куча runs по текущей строке. При равенстве первым идёт более ранний run,
+// чтобы равные строки сохраняли порядок входа
+type runHeap struct {
+	runs []*runReader
+	less func(string, string) bool
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+
+func (h *runHeap) Less(i, j int) bool {
+	lhs, rhs := h.runs[i], h.runs[j]
+	if h.less(lhs.line, rhs.line) {
+		return true
+	}
+	if h.less(rhs.line, lhs.line) {
+		return false
+	}
+	return lhs.index < rhs.index
+}
+
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runHeap) Push(x any) { h.runs = append(h.runs, x.(*runReader)) }
+
+func (h *runHeap) Pop() any {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+// mergeFiles сливает отсортированные файлы k-путевым слиянием за один проход, открывая их все сразу.
+// Число файлов ограничивает externalSorter.merge
+func mergeFiles(w io.Writer, names []string, params parameters) error {
+	comparator := newComparator(params)
+	h := &runHeap{less: comparator.less}
+	for i, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		defer file.Close()
+
+		run := &runReader{reader: bufio.NewReader(file), index: i}
+		line, err := readLine(run.reader)
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		run.line = line
+		h.runs = append(h.runs, run)
+	}
+	heap.Init(h)
+
+	writer := bufio.NewWriter(w)
+	var prev string
+	written := false
+	for h.Len() > 0 {
+		run := h.runs[0]
+		line := run.line
+
+		// с -u из строк с равным ключом остаётся первая: runs идут в порядке входа,
+		// а при равенстве куча отдаёт строку из более раннего run
+		if !params.isUnique || !written || comparator.compareKeys(prev, line) != 0 {
+			writer.WriteString(line)
+			writer.WriteByte('\n')
+			prev, written = line, true
+		}
+
+		next, err := readLine(run.reader)
+		switch {
+		case errors.Is(err, io.EOF):
+			heap.Pop(h)
+		case err != nil:
+			return fmt.Errorf("merge: %w", err)
+		default:
+			run.line = next
+			heap.Fix(h, 0)
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// keyMode - способ сравнения значений ключа
+type keyMode int
+
+const (
+	modeString keyMode = iota
+	modeNumeric
+	modeHuman
+	modeMonth
+	modeVersion
+	modeGeneral
+)
+
+// modeOptions - буквы опций, задающих способ сравнения
+var modeOptions = map[rune]keyMode{
+	'n': modeNumeric,
+	'h': modeHuman,
+	'M': modeMonth,
+	'V': modeVersion,
+	'g': modeGeneral,
+}
+
+// keyOptions - модификаторы ключа (-k 2,2nr) или глобальные опции (-n -r -b)
+type keyOptions struct {
+	mode    keyMode
+	reverse bool
+	// -f: сравнение без учёта регистра
+	foldCase bool
+	// -b: пропускать пробелы в начале поля начала (и конца) ключа
+	skipStartBlanks bool
+	skipEndBlanks   bool
+}
+
+// keySpec - ключ сортировки в формате POSIX: -k POS1[,POS2], где POS - F[.C][OPTS].
+// Номера полей и символов начинаются с 1. endField == 0 - до конца строки,
+// endChar == 0 - до конца поля endField
+type keySpec struct {
+	startField int
+	startChar  int
+	endField   int
+	endChar    int
+	// для --csv/--tsv поле можно задать именем колонки, номер находится по заголовку
+	startName string
+	endName   string
+
+	options keyOptions
+	// у ключа есть собственные модификаторы, глобальные опции к нему не применяются
+	hasOptions bool
+}
+
+// keyList - повторяемый флаг -k
+type keyList []keySpec
+
+func (k *keyList) String() string {
+	return fmt.Sprint(*k)
+}
+
+func (k *keyList) Set(value string) error {
+	key, err := parseKey(value)
+	if err != nil {
+		return err
+	}
+	*k = append(*k, key)
+	return nil
+}
+
+func parseKey(value string) (keySpec, error) {
+	var key keySpec
+	start, end, hasEnd := strings.Cut(value, ",")
+
+	var err error
+	key.startField, key.startName, key.startChar, err = parseKeyPos(start, &key, true)
+	if err != nil {
+		return keySpec{}, fmt.Errorf("bad key %q: %w", value, err)
+	}
+	if key.startChar == 0 {
+		key.startChar = 1
+	}
+	if hasEnd {
+		key.endField, key.endName, key.endChar, err = parseKeyPos(end, &key, false)
+		if err != nil {
+			return keySpec{}, fmt.Errorf("bad key %q: %w", value, err)
+		}
+	} else if key.startName != "" {
+		// колонка, заданная именем, - это только она, а не всё до конца строки
+		key.endName = key.startName
+	}
+	return key, nil
+}
+
+// parseKeyPos разбирает F[.C][OPTS] или NAME[:OPTS] и добавляет модификаторы в key
+func parseKeyPos(pos string, key *keySpec, isStart bool) (field int, name string, char int, err error) {
+	if pos != "" && !isDigit(pos[0]) {
+		name, opts, _ := strings.Cut(pos, ":")
+		if name == "" {
+			return 0, "", 0, fmt.Errorf("column name expected in %q", pos)
+		}
+		if err := applyKeyOptions(key, opts, isStart); err != nil {
+			return 0, "", 0, err
+		}
+		return 0, name, 0, nil
+	}
+
+	digits := func() (int, error) {
+		i := 0
+		for i < len(pos) && '0' <= pos[i] && pos[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("number expected in %q", pos)
+		}
+		n, err := strconv.Atoi(pos[:i])
+		pos = pos[i:]
+		return n, err
+	}
+
+	if field, err = digits(); err != nil {
+		return 0, "", 0, err
+	}
+	if field == 0 {
+		return 0, "", 0, fmt.Errorf("field number is zero")
+	}
+	if strings.HasPrefix(pos, ".") {
+		pos = pos[1:]
+		if char, err = digits(); err != nil {
+			return 0, "", 0, err
+		}
+		if char == 0 && isStart {
+			return 0, "", 0, fmt.Errorf("character offset is zero")
+		}
+	}
+
+	if err := applyKeyOptions(key, pos, isStart); err != nil {
+		return 0, "", 0, err
+	}
+	return field, "", char, nil
+}
+
+func applyKeyOptions(key *keySpec, opts string, isStart bool) error {
+	for _, opt := range opts {
+		if err := applyKeyOption(&key.options, opt, isStart); err != nil {
+			return err
+		}
+		key.hasOptions = true
+	}
+	return nil
+}
+
+func applyKeyOption(options *keyOptions, opt rune, isStart bool) error {
+	if mode, ok := modeOptions[opt]; ok {
+		return options.setMode(mode)
+	}
+	switch opt {
+	case 'b':
+		if isStart {
+			options.skipStartBlanks = true
+		} else {
+			options.skipEndBlanks = true
+		}
+	case 'f':
+		options.foldCase = true
+	case 'r':
+		options.reverse = true
+	default:
+		return fmt.Errorf("unknown key option %q", opt)
+	}
+	return nil
+}
+
+// setMode запрещает сочетать разные способы сравнения в одном ключе, как GNU sort
+func (o *keyOptions) setMode(mode keyMode) error {
+	if o.mode != modeString && o.mode != mode {
+		return fmt.Errorf("options %q and %q are incompatible", modeLetter(o.mode), modeLetter(mode))
+	}
+	o.mode = mode
+	return nil
+}
+
+func modeLetter(mode keyMode) rune {
+	for letter, m := range modeOptions {
+		if m == mode {
+			return letter
+		}
+	}
+	return 0
+}
+
+// comparator сравнивает строки по цепочке ключей, а при их равенстве - строки целиком
+type comparator struct {
+	keys      []keySpec
+	separator rune
+	// глобальный -r действует и на итоговое сравнение строк целиком
+	reverse bool
+	// -s и -u: строки с равными ключами не сравниваются целиком и сохраняют порядок входа
+	stable bool
+	// --locale: строки сравниваются по правилам языка; nil - побайтово
+	collation *collation
+}
+
+// globalOptions собирает глобальные опции сравнения в модификаторы ключа
+func globalOptions(params parameters) (keyOptions, error) {
+	options := keyOptions{
+		reverse:         params.isReverse,
+		foldCase:        params.foldCase,
+		skipStartBlanks: params.skipBlanks,
+		skipEndBlanks:   params.skipBlanks,
+	}
+	for mode, enabled := range map[keyMode]bool{
+		modeNumeric: params.byNumeric,
+		modeHuman:   params.byHuman,
+		modeMonth:   params.byMonth,
+		modeVersion: params.byVersion,
+		modeGeneral: params.byGeneral,
+	} {
+		if !enabled {
+			continue
+		}
+		if err := options.setMode(mode); err != nil {
+			return keyOptions{}, err
+		}
+	}
+	return options, nil
+}
+
+func newComparator(params parameters) *comparator {
+	// несовместимые глобальные опции и неизвестная локаль отсекаются при разборе аргументов
+	global, _ := globalOptions(params)
+	collation, _ := newCollation(params.locale)
+
+	// без -k ключом служит вся строка
+	keys := params.keys
+	if len(keys) == 0 {
+		keys = []keySpec{{startField: 1, startChar: 1}}
+	}
+
+	c := &comparator{
+		separator: params.separator,
+		reverse:   params.isReverse,
+		stable:    params.isStable || params.isUnique,
+		collation: collation,
+	}
+	for _, key := range keys {
+		if !key.hasOptions {
+			key.options = global
+		}
+		c.keys = append(c.keys, key)
+	}
+	return c
+}
+
+func (c *comparator) less(lhs, rhs string) bool {
+	return c.compare(lhs, rhs) < 0
+}
+
+// compareKeys сравнивает только ключи, без итогового сравнения строк целиком
+func (c *comparator) compareKeys(lhs, rhs string) int {
+	for _, key := range c.keys {
+		if res := c.compareKey(c.extract(lhs, key), c.extract(rhs, key), key); res != 0 {
+			return res
+		}
+	}
+	return 0
+}
+
+func (c *comparator) compareKey(lhs, rhs string, key keySpec) int {
+	res := c.compareValues(lhs, rhs, key.options)
+	if key.options.reverse {
+		res = -res
+	}
+	return res
+}
+
+func (c *comparator) compare(lhs, rhs string) int {
+	if res := c.compareKeys(lhs, rhs); res != 0 || c.stable {
+		return res
+	}
+	return c.compareWhole(lhs, rhs)
+}
+
+// compareWhole - последнее сравнение, когда ключи равны: строки целиком
+func (c *comparator) compareWhole(lhs, rhs string) int {
+	res := 0
+	if c.collation != nil {
+		res = c.collation.compare(lhs, rhs, false)
+	}
+	// разные строки могут совпадать по правилам языка, тогда порядок задают байты
+	if res == 0 {
+		res = strings.Compare(lhs, rhs)
+	}
+	if c.reverse {
+		res = -res
+	}
+	return res
+}
+
+func (c *comparator) compareValues(lhs, rhs string, options keyOptions) int {
+	switch options.mode {
+	case modeNumeric:
+		return compareNumeric(lhs, rhs)
+	case modeHuman:
+		return compareHuman(lhs, rhs)
+	case modeMonth:
+		return compareMonth(lhs, rhs)
+	case modeVersion:
+		return compareVersion(lhs, rhs)
+	case modeGeneral:
+		return compareGeneral(lhs, rhs)
+	case modeString:
+		if c.collation != nil {
+			return c.collation.compare(lhs, rhs, options.foldCase)
+		}
+		if options.foldCase {
+			return compareFolded(lhs, rhs)
+		}
+	}
+	return strings.Compare(lhs, rhs)
+}
+
+func isBlank(r byte) bool {
+	return r == ' ' || r == '\t'
+}
+
+func skipBlanks(line string, pos int) int {
+	for pos < len(line) && isBlank(line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// skipField возвращает позицию конца поля, начинающегося в pos. Без -t поле - это
+// пробелы перед ним и следующие за ними непробельные символы, как в POSIX sort
+func (c *comparator) skipField(line string, pos int) int {
+	if c.separator != 0 {
+		if i := strings.IndexRune(line[pos:], c.separator); i >= 0 {
+			return pos + i
+		}
+		return len(line)
+	}
+	pos = skipBlanks(line, pos)
+	for pos < len(line) && !isBlank(line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// nextField - начало поля, следующего за полем, которое начинается в pos
+func (c *comparator) nextField(line string, pos int) int {
+	pos = c.skipField(line, pos)
+	if c.separator != 0 && pos < len(line) {
+		pos += utf8.RuneLen(c.separator)
+	}
+	return pos
+}
+
+// skipChars сдвигает позицию на n символов (не байт), не выходя за конец строки
+func skipChars(line string, pos, n int) int {
+	for ; n > 0 && pos < len(line); n-- {
+		_, size := utf8.DecodeRuneInString(line[pos:])
+		pos += size
+	}
+	return pos
+}
+
+// extract возвращает часть строки, являющуюся ключом
+func (c *comparator) extract(line string, key keySpec) string {
+	begin := 0
+	for i := 1; i < key.startField && begin < len(line); i++ {
+		begin = c.nextField(line, begin)
+	}
+	if key.options.skipStartBlanks {
+		begin = skipBlanks(line, begin)
+	}
+	begin = skipChars(line, begin, key.startChar-1)
+
+	end := len(line)
+	if key.endField != 0 {
+		end = 0
+		for i := 1; i < key.endField && end < len(line); i++ {
+			end = c.nextField(line, end)
+		}
+		if key.endChar == 0 {
+			end = c.skipField(line, end)
+		} else {
+			if key.options.skipEndBlanks {
+				end = skipBlanks(line, end)
+			}
+			end = skipChars(line, end, key.endChar)
+		}
+	}
+
+	if end <= begin {
+		return ""
+	}
+	return line[begin:end]
+}
+
+// compareNumeric сравнивает числа в начале строк как GNU sort -n: пробелы в начале
+// пропускаются, нечисловые значения равны нулю. Числа сравниваются как строки цифр,
+// поэтому точность не ограничена размером int или float64
+func compareNumeric(lhs, rhs string) int {
+	lneg, lint, lfrac, _ := parseNumber(lhs)
+	rneg, rint, rfrac, _ := parseNumber(rhs)
+
+	// -0 и 0 равны
+	if lint == "" && lfrac == "" {
+		lneg = false
+	}
+	if rint == "" && rfrac == "" {
+		rneg = false
+	}
+	if lneg != rneg {
+		if lneg {
+			return -1
+		}
+		return 1
+	}
+
+	res := len(lint) - len(rint)
+	if res == 0 {
+		res = strings.Compare(lint, rint)
+	}
+	if res == 0 {
+		res = strings.Compare(lfrac, rfrac)
+	}
+	switch {
+	case res < 0:
+		res = -1
+	case res > 0:
+		res = 1
+	}
+	if lneg {
+		return -res
+	}
+	return res
+}
+
+// parseNumber выделяет из начала строки знак, целую часть без ведущих нулей,
+// дробную часть без завершающих нулей и остаток строки после числа
+func parseNumber(s string) (negative bool, intPart, fracPart, rest string) {
+	pos := skipBlanks(s, 0)
+	if pos < len(s) && s[pos] == '-' {
+		negative = true
+		pos++
+	}
+
+	start := pos
+	for pos < len(s) && '0' <= s[pos] && s[pos] <= '9' {
+		pos++
+	}
+	intPart = strings.TrimLeft(s[start:pos], "0")
+
+	if pos < len(s) && s[pos] == '.' {
+		pos++
+		start = pos
+		for pos < len(s) && '0' <= s[pos] && s[pos] <= '9' {
+			pos++
+		}
+		fracPart = strings.TrimRight(s[start:pos], "0")
+	}
+	return negative, intPart, fracPart, s[pos:]
+}
@@ -1,172 +1,301 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"sort"
-	"strconv"
+	"os/signal"
+	"runtime"
 	"strings"
+	"syscall"
+	"unicode/utf8"
 )
 
 type parameters struct {
-	column    int
-	byNumeric bool
-	isReverse bool
-	isUnique  bool
-	filenames []string
+	keys       []keySpec
+	separator  rune
+	skipBlanks bool
+	byNumeric  bool
+	byHuman    bool
+	byMonth    bool
+	byVersion  bool
+	byGeneral  bool
+	foldCase   bool
+	isReverse  bool
+	isUnique   bool
+	isStable   bool
+	filenames  []string
+
+	// -c / -C: только проверить, что вход уже отсортирован; quietCheck - без сообщения
+	check      bool
+	quietCheck bool
+	// -m: входные файлы уже отсортированы, их нужно только слить
+	isMerge bool
+	// число горутин для сортировки в памяти
+	parallel int
+	// --locale: правила сравнения строк (ru_RU.UTF-8, de); пусто - побайтово
+	locale string
+	// -o: файл для результата, может совпадать с одним из входных
+	output string
+	// --csv/--tsv: вход - записи CSV с заголовком, separator - разделитель полей CSV
+	csv bool
+
+	// бюджет памяти в байтах, после которого отсортированные части сбрасываются на диск; 0 - без ограничения
+	bufferSize int64
+	tmpDir     string
 }
 
 var params parameters
 
-func parseArgsIntoParams() parameters {
-	colNum := flag.Int("k", 1, "Number of column to sort")
+func parseArgsIntoParams() (parameters, error) {
+	var keys keyList
+	flag.Var(&keys, "k", "Sort key POS1[,POS2], POS is F[.C][OPTS] with OPTS from bfghMnrV (repeatable)")
+	separator := flag.String("t", "", "Field separator instead of blank-to-non-blank transition")
+	skipBlanks := flag.Bool("b", false, "Ignore leading blanks in keys")
 	byNum := flag.Bool("n", false, "Numeric compare")
+	byHuman := flag.Bool("h", false, "Compare human readable sizes (2K, 1.5G)")
+	byMonth := flag.Bool("M", false, "Compare month names (JAN < ... < DEC)")
+	byVersion := flag.Bool("V", false, "Natural sort of version numbers")
+	byGeneral := flag.Bool("g", false, "Compare general floating point numbers")
+	foldCase := flag.Bool("f", false, "Fold lower case to upper case characters")
 	isUn := flag.Bool("u", false, "Unique strings")
 	isRev := flag.Bool("r", false, "Reverse order")
-	flag.Parse()
-
-	params := parameters{
-		column:    *colNum,
-		byNumeric: *byNum,
-		isReverse: *isRev,
-		isUnique:  *isUn,
-		filenames: flag.Args(),
+	isStable := flag.Bool("s", false, "Stable sort: keep input order of lines with equal keys")
+	check := flag.Bool("c", false, "Check whether input is sorted, report the first disorder")
+	quietCheck := flag.Bool("C", false, "Like -c, but do not report the first disorder")
+	isMerge := flag.Bool("m", false, "Merge already sorted files")
+	parallel := flag.Int("parallel", 1, "Sort with N goroutines (0 - number of CPUs)")
+	locale := flag.String("locale", "", "Collate strings by Unicode CLDR rules of the locale, e.g. ru_RU.UTF-8 (default - byte order)")
+	isCSV := flag.Bool("csv", false, "Input is CSV with a header row; keys may name columns: -k price:n")
+	isTSV := flag.Bool("tsv", false, "Like --csv, but fields are separated by tabs")
+	output := flag.String("o", "", "Write result to FILE instead of standard output, FILE may be one of the inputs")
+	bufferSize := flag.String("S", "0", "Memory budget before spilling to temporary files: SIZE[bKMGT], KiB by default (0 - unlimited)")
+	tmpDir := flag.String("T", os.TempDir(), "Directory for temporary files")
+	if err := flag.CommandLine.Parse(expandShortFlags(flag.CommandLine, os.Args[1:])); err != nil {
+		return parameters{}, err
 	}
 
-	return params
-}
-
-// readDataFromFiles поддерживает чтение из нескольких файлов, указанных в параметрах, по аналогии с консольной утилитой sort
-func readDataFromFiles(filenames []string) ([]string, error) {
-	readDataFromFile := func(name string) ([]string, error) {
-		file, err := os.Open(name)
-		if err != nil {
-			return []string{}, err
-		}
-		defer file.Close()
+	size, err := parseSize(*bufferSize)
+	if err != nil {
+		return parameters{}, err
+	}
 
-		scanner := bufio.NewScanner(file)
-		lines := []string{}
-		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
+	var sep rune
+	if *separator != "" {
+		if utf8.RuneCountInString(*separator) != 1 {
+			return parameters{}, fmt.Errorf("separator must be a single character: %q", *separator)
 		}
-		if err := scanner.Err(); err != nil {
-			return []string{}, err
-		}
-		return lines, nil
+		sep, _ = utf8.DecodeRuneInString(*separator)
 	}
 
-	var data []string
-	for _, name := range filenames {
-		newLines, err := readDataFromFile(name)
-		if err != nil {
-			return []string{}, err
-		}
-		data = append(data, newLines...)
+	switch {
+	case *isCSV && *isTSV:
+		return parameters{}, fmt.Errorf("options --csv and --tsv are incompatible")
+	case *isTSV && sep != 0 && sep != '\t':
+		return parameters{}, fmt.Errorf("option -t is incompatible with --tsv")
+	case *isCSV && sep == 0:
+		sep = ','
+	case *isTSV:
+		sep = '\t'
 	}
 
-	return data, nil
-}
+	params := parameters{
+		keys:       keys,
+		separator:  sep,
+		skipBlanks: *skipBlanks,
+		byNumeric:  *byNum,
+		byHuman:    *byHuman,
+		byMonth:    *byMonth,
+		byVersion:  *byVersion,
+		byGeneral:  *byGeneral,
+		foldCase:   *foldCase,
+		isReverse:  *isRev,
+		isUnique:   *isUn,
+		isStable:   *isStable,
+		filenames:  flag.Args(),
+
+		check:      *check,
+		quietCheck: *quietCheck,
+		isMerge:    *isMerge,
+		parallel:   *parallel,
+		locale:     *locale,
+		output:     *output,
+		csv:        *isCSV || *isTSV,
 
-func compareAsNumbers(lhs, rhs string) bool {
-	lnum, lerr := strconv.Atoi(lhs)
-	rnum, rerr := strconv.Atoi(rhs)
+		bufferSize: size,
+		tmpDir:     *tmpDir,
+	}
 
-	//сравниваем как обычные строки
-	if lerr != nil && rerr != nil {
-		return lhs < rhs
+	if _, err := globalOptions(params); err != nil {
+		return parameters{}, err
+	}
+	if _, err := newCollation(params.locale); err != nil {
+		return parameters{}, err
+	}
+	if params.check && params.quietCheck {
+		return parameters{}, fmt.Errorf("options -c and -C are incompatible")
+	}
+	if (params.check || params.quietCheck) && len(params.filenames) != 1 {
+		return parameters{}, fmt.Errorf("check mode expects exactly one file")
 	}
-	// строки без числовых значений в указанной колонке выводятся в самом конце
-	if lerr != nil || rerr != nil {
-		return lerr == nil
+	if params.csv && (params.check || params.quietCheck || params.isMerge || params.bufferSize > 0) {
+		return parameters{}, fmt.Errorf("options -c, -C, -m and -S are not supported with --csv and --tsv")
 	}
-	return lnum < rnum
+	for _, key := range params.keys {
+		if !params.csv && (key.startName != "" || key.endName != "") {
+			return parameters{}, fmt.Errorf("column names in keys require --csv or --tsv")
+		}
+	}
+	if params.parallel <= 0 {
+		params.parallel = runtime.NumCPU()
+	}
+
+	return params, nil
 }
 
-func compareAsStrings(lhs, rhs string) bool {
-	return lhs < rhs
+// readDataFromFiles поддерживает чтение из нескольких файлов, указанных в параметрах, по аналогии с консольной утилитой sort
+func readDataFromFiles(filenames []string) ([]string, error) {
+	var data []string
+	err := readLines(filenames, func(line string) error {
+		data = append(data, line)
+		return nil
+	})
+	if err != nil {
+		return []string{}, err
+	}
+	return data, nil
 }
 
 func doSort(data []string, params parameters) []string {
+	c := newComparator(params)
+	jobs := min(params.parallel, len(data)/minParallelLines)
+	data = parallelSort(data, jobs, c.less, c.stable)
 	if params.isUnique {
-		data = makeStringsUnique(data)
+		data = uniqueSorted(data, func(lhs, rhs string) bool { return c.compareKeys(lhs, rhs) == 0 })
 	}
+	return data
+}
 
-	var valueComparator func(string, string) bool
-	if params.byNumeric {
-		valueComparator = compareAsNumbers
-	} else {
-		valueComparator = compareAsStrings
+// expandShortFlags разрешает запись в стиле GNU: "-k2,2n" превращается в "-k 2,2n",
+// "-nr" - в "-n -r". Известные флаги (-csv) и значения флагов не меняются
+func expandShortFlags(flags *flag.FlagSet, args []string) []string {
+	takesValue := func(f *flag.Flag) bool {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		return !(ok && b.IsBoolFlag())
 	}
 
-	// логика сравнения:
-	// осуществляется проверка существования указанной колонки, сначала выводятся строки, у которых ее не существует,
-	// сравниваются по дефолтной первой. Функция сравнения определяется в зависимости от наличия ключа -n
-	compareLogic := func(i, j int) bool {
-		lhs := strings.Split(data[i], " ")
-		rhs := strings.Split(data[j], " ")
-		if len(lhs) == 0 {
-			return true
-		}
-		if len(rhs) == 0 {
-			return false
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			res = append(res, arg)
+			if arg == "--" {
+				return append(res, args[i+1:]...)
+			}
+			continue
 		}
 
-		if len(lhs) < params.column && len(rhs) >= params.column {
-			return true
-		}
-		if len(lhs) >= params.column && len(rhs) < params.column {
-			return false
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if f := flags.Lookup(name); f != nil || strings.HasPrefix(arg, "--") {
+			res = append(res, arg)
+			// значение в следующем аргументе может начинаться с "-": "-t -"
+			if f != nil && !hasValue && takesValue(f) && i+1 < len(args) {
+				i++
+				res = append(res, args[i])
+			}
+			continue
 		}
 
-		if len(lhs) < params.column && len(rhs) < params.column {
-			return valueComparator(lhs[0], rhs[0])
-		}
-		if len(lhs) >= params.column && len(rhs) >= params.column {
-			return valueComparator(lhs[params.column-1], rhs[params.column-1])
+		for j := 1; j < len(arg); j++ {
+			f := flags.Lookup(arg[j : j+1])
+			res = append(res, "-"+arg[j:j+1])
+			if f != nil && takesValue(f) {
+				if j+1 < len(arg) {
+					res = append(res, arg[j+1:])
+				} else if i+1 < len(args) {
+					i++
+					res = append(res, args[i])
+				}
+				break
+			}
 		}
-		// Ошибка логики программы. Используется именно паника, так как это скрипт, здесь можно просто выйти из программы
-		panic("DEBUG: code should not run here")
 	}
-
-	if params.isReverse {
-		sort.Slice(data, func(i, j int) bool {
-			return !compareLogic(i, j)
-		})
-	} else {
-		sort.Slice(data, compareLogic)
-	}
-
-	return data
+	return res
 }
 
-func makeStringsUnique(strs []string) []string {
-	keys := make(map[string]struct{}, len(strs))
-	newData := []string{}
-
-	for i := 0; i < len(strs); i++ {
-		if _, ok := keys[strs[i]]; !ok {
-			keys[strs[i]] = struct{}{}
-			newData = append(newData, strs[i])
+// uniqueSorted оставляет из каждой группы строк с равными ключами первую, как sort -u в GNU:
+// с -n строки "1" и "01" - повторы. data должен быть отсортирован стабильно
+func uniqueSorted[T any](data []T, sameKey func(T, T) bool) []T {
+	res := data[:0]
+	for _, line := range data {
+		if len(res) == 0 || !sameKey(res[len(res)-1], line) {
+			res = append(res, line)
 		}
 	}
-	return newData
+	return res
 }
 
 func main() {
 	// пример ввода параметров: go run main.go -k 2 -n file.txt numeric.txt
 	// в данном примере произойдет сортировка данных из двух файлов с параметрами: числовая сортировка данных второй колонки
-	params = parseArgsIntoParams()
-	data, err := readDataFromFiles(params.filenames)
+	params, err := parseArgsIntoParams()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	data = doSort(data, params)
-	for _, str := range data {
-		fmt.Println(str)
+	if params.check || params.quietCheck {
+		err := checkSorted(params.filenames[0], params)
+		var d *disorder
+		if errors.As(err, &d) {
+			if params.check {
+				fmt.Fprintln(os.Stderr, "sort:", d)
+			}
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	out, err := createOutput(params.output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	sorter := newExternalSorter(params)
+	// временные файлы удаляются и при прерывании сортировки или закрытии вывода (head)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGPIPE)
+	go func() {
+		<-sigs
+		sorter.cleanup()
+		out.abort()
+		os.Exit(130)
+	}()
+
+	switch {
+	case params.csv:
+		err = sortCSVFiles(out, params)
+	case params.isMerge:
+		err = sorter.merge(out, params.filenames, false)
+	default:
+		err = readLines(params.filenames, sorter.add)
+		if err == nil {
+			err = sorter.output(out)
+		}
+	}
+	if cleanupErr := sorter.cleanup(); err == nil {
+		err = cleanupErr
+	}
+	if err == nil {
+		err = out.commit()
+	} else {
+		out.abort()
+	}
+	if err != nil {
+		log.Fatalln(err)
 	}
 }
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 )
 
+// columnKeys - ключ по одной колонке, как -k N,N
+func columnKeys(n int) []keySpec {
+	return []keySpec{{startField: n, startChar: 1, endField: n}}
+}
+
 func Test_readDataFromFiles(t *testing.T) {
 	type args struct {
 		filenames []string
@@ -53,45 +64,105 @@ func Test_readDataFromFiles(t *testing.T) {
 	}
 }
 
-func Test_makeStringsUnique(t *testing.T) {
-	type args struct {
-		strs []string
-	}
+// Ожидаемые результаты получены GNU sort -u с LC_ALL=C: из равных по ключу строк остаётся первая
+func Test_doSort_unique(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want []string
+		name   string
+		data   []string
+		params parameters
+		want   []string
 	}{
 		{
 			name: "Default",
-			args: args{strs: []string{"a", "b", "12", "a", "12", "c"}},
-			want: []string{"a", "b", "12", "c"},
+			data: []string{"a", "b", "12", "a", "12", "c"},
+			want: []string{"12", "a", "b", "c"},
 		},
 		{
 			name: "All unique",
-			args: args{strs: []string{"a", "b", "c", "d"}},
+			data: []string{"a", "b", "c", "d"},
 			want: []string{"a", "b", "c", "d"},
 		},
 		{
 			name: "All same",
-			args: args{strs: []string{"a", "a", "a", "a"}},
+			data: []string{"a", "a", "a", "a"},
 			want: []string{"a"},
 		},
 		{
 			name: "Empty slice",
-			args: args{strs: []string{}},
+			data: []string{},
 			want: []string{},
 		},
+		{
+			name:   "Equal numbers",
+			data:   []string{"1", "01", "2"},
+			params: parameters{byNumeric: true},
+			want:   []string{"1", "2"},
+		},
+		{
+			name:   "Equal keys",
+			data:   []string{"b 1", "a 1", "c 2"},
+			params: parameters{keys: columnKeys(2)},
+			want:   []string{"b 1", "c 2"},
+		},
+		{
+			name:   "Equal keys reversed",
+			data:   []string{"b 1", "a 1", "c 2"},
+			params: parameters{keys: columnKeys(2), isReverse: true},
+			want:   []string{"c 2", "b 1"},
+		},
+		{
+			name:   "Fold case",
+			data:   []string{"B", "b", "a"},
+			params: parameters{foldCase: true},
+			want:   []string{"a", "B"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			params.isUnique = true
+			if got := doSort(append([]string{}, tt.data...), params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doSort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Без ключей и модификаторов -u по-прежнему убирает только точные дубликаты строк,
+// как прежний makeStringsUnique. Отличаются только порядок вывода (он теперь отсортирован)
+// и строки с равными ключами при -k/-n/-f (см. Test_doSort_unique)
+func Test_doSort_uniqueWholeLine(t *testing.T) {
+	tests := []struct {
+		name string
+		data []string
+		want []string
+	}{
+		{
+			name: "Default",
+			data: []string{"a", "b", "12", "a", "12", "c"},
+			want: []string{"12", "a", "b", "c"},
+		},
+		{
+			name: "Differ only in case or spaces",
+			data: []string{"a", "A", "a ", " a", "a"},
+			want: []string{" a", "A", "a", "a "},
+		},
+		{
+			name: "Same number written differently",
+			data: []string{"1", "01", "1"},
+			want: []string{"01", "1"},
+		},
 		{
 			name: "Nil slice",
-			args: args{strs: nil},
-			want: []string{},
+			data: nil,
+			want: nil,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := makeStringsUnique(tt.args.strs); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("makeStringsUnique() = %v, want %v", got, tt.want)
+			got := doSort(append([]string(nil), tt.data...), parameters{isUnique: true})
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("doSort() = %q, want %q", got, tt.want)
 			}
 		})
 	}
@@ -123,7 +194,7 @@ func Test_doSort_numeric(t *testing.T) {
 	}{
 		{
 			name: "k1",
-			args: args{data: base, params: parameters{column: 1, byNumeric: false, isReverse: false, isUnique: false}},
+			args: args{data: base, params: parameters{byNumeric: false, isReverse: false, isUnique: false}},
 			want: []string{
 				"abhishek 44",
 				"divyam 11",
@@ -136,7 +207,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k2",
-			args: args{data: base, params: parameters{column: 2, byNumeric: false, isReverse: false, isUnique: false}},
+			args: args{data: base, params: parameters{keys: columnKeys(2), byNumeric: false, isReverse: false, isUnique: false}},
 			want: []string{
 				"harsh",
 				"satish 1",
@@ -149,7 +220,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k3",
-			args: args{data: base, params: parameters{column: 3, byNumeric: false, isReverse: false, isUnique: false}},
+			args: args{data: base, params: parameters{keys: columnKeys(3), byNumeric: false, isReverse: false, isUnique: false}},
 			want: []string{
 				"abhishek 44",
 				"divyam 11",
@@ -162,7 +233,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k1 reversed",
-			args: args{data: base, params: parameters{column: 1, byNumeric: false, isReverse: true, isUnique: false}},
+			args: args{data: base, params: parameters{byNumeric: false, isReverse: true, isUnique: false}},
 			want: []string{
 				"zvisehn 6",
 				"satish 1",
@@ -175,7 +246,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k1 numeric",
-			args: args{data: base, params: parameters{column: 1, byNumeric: true, isReverse: false, isUnique: false}},
+			args: args{data: base, params: parameters{byNumeric: true, isReverse: false, isUnique: false}},
 			want: []string{
 				"abhishek 44",
 				"divyam 11",
@@ -188,7 +259,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k2 numeric",
-			args: args{data: base, params: parameters{column: 2, byNumeric: true, isReverse: false, isUnique: false}},
+			args: args{data: base, params: parameters{keys: columnKeys(2), byNumeric: true, isReverse: false, isUnique: false}},
 			want: []string{
 				"harsh",
 				"satish 1",
@@ -201,7 +272,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k2 numeric reversed",
-			args: args{data: base, params: parameters{column: 2, byNumeric: true, isReverse: true, isUnique: false}},
+			args: args{data: base, params: parameters{keys: columnKeys(2), byNumeric: true, isReverse: true, isUnique: false}},
 			want: []string{
 				"abhishek 44",
 				"rajan 22",
@@ -214,7 +285,7 @@ func Test_doSort_numeric(t *testing.T) {
 		},
 		{
 			name: "k1 unique",
-			args: args{data: baseDouble, params: parameters{column: 1, byNumeric: false, isReverse: false, isUnique: true}},
+			args: args{data: baseDouble, params: parameters{byNumeric: false, isReverse: false, isUnique: true}},
 			want: []string{
 				"abhishek 44",
 				"divyam 11",
@@ -234,3 +305,623 @@ func Test_doSort_numeric(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "512M", want: 512 << 20},
+		{value: "100b", want: 100},
+		{value: "2G", want: 2 << 30},
+		{value: "10", want: 10 << 10},
+		{value: "M", wantErr: true},
+		{value: "-1K", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Внешняя сортировка с маленьким бюджетом памяти должна давать тот же результат, что и сортировка в памяти
+func Test_externalSorter_sameAsInMemory(t *testing.T) {
+	var data []string
+	for i := 0; i < 500; i++ {
+		data = append(data, fmt.Sprintf("name%d %d", (i*7919)%97, (i*31)%50))
+	}
+	data = append(data, "harsh", "", "name1 1", "name1 1")
+
+	for _, params := range []parameters{
+		{keys: columnKeys(1)},
+		{keys: columnKeys(2), byNumeric: true},
+		{keys: columnKeys(2), byNumeric: true, isUnique: true},
+		{isUnique: true},
+		{keys: columnKeys(2), isReverse: true, isUnique: true},
+	} {
+		// fanIn 2 и 3 - несколько проходов слияния через промежуточные runs
+		for _, fanIn := range []int{mergeFanIn, 2, 3} {
+			checkExternalSort(t, data, params, fanIn)
+		}
+	}
+}
+
+func checkExternalSort(t *testing.T, data []string, params parameters, fanIn int) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	params.tmpDir = tmpDir
+	params.bufferSize = 1 << 9
+
+	sorter := newExternalSorter(params)
+	sorter.fanIn = fanIn
+	for _, line := range data {
+		if err := sorter.add(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sorter.runs) < 10 {
+		t.Fatalf("params %+v: expected data to be spilled, runs = %d", params, len(sorter.runs))
+	}
+
+	got := &bytes.Buffer{}
+	if err := sorter.output(got); err != nil {
+		t.Fatal(err)
+	}
+	// промежуточные runs удаляются сразу после слияния
+	if entries, _ := os.ReadDir(sorter.dir); len(entries) > fanIn {
+		t.Errorf("fanIn %d: %d runs left after merge", fanIn, len(entries))
+	}
+	if err := sorter.cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	want := doSort(append([]string{}, data...), params)
+	if got.String() != strings.Join(want, "\n")+"\n" {
+		t.Errorf("params %+v, fanIn %d: external sort differs from in-memory sort", params, fanIn)
+	}
+}
+
+// cleanup из обработчика сигнала может выполняться одновременно со сбросом runs (проверяется с -race)
+func Test_externalSorter_cleanupConcurrent(t *testing.T) {
+	sorter := newExternalSorter(parameters{tmpDir: t.TempDir(), bufferSize: 64})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if err := sorter.add(fmt.Sprintf("line %d", i)); err != nil {
+				return
+			}
+		}
+	}()
+	sorter.cleanup()
+	<-done
+
+	if _, err := sorter.createRun(); err == nil {
+		t.Error("createRun() after cleanup succeeded")
+	}
+}
+
+func Test_parseKey(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    keySpec
+		wantErr bool
+	}{
+		{value: "2", want: keySpec{startField: 2, startChar: 1}},
+		{value: "2,2n", want: keySpec{startField: 2, startChar: 1, endField: 2, options: keyOptions{mode: modeNumeric}, hasOptions: true}},
+		{value: "1.3b,1.5r", want: keySpec{startField: 1, startChar: 3, endField: 1, endChar: 5,
+			options: keyOptions{reverse: true, skipStartBlanks: true}, hasOptions: true}},
+		{value: "3,3.0", want: keySpec{startField: 3, startChar: 1, endField: 3}},
+		{value: "0", wantErr: true},
+		{value: "1.0", wantErr: true},
+		{value: "1x", wantErr: true},
+		{value: ",2", wantErr: true},
+		{value: "price:nr", want: keySpec{startChar: 1, startName: "price", endName: "price",
+			options: keyOptions{mode: modeNumeric, reverse: true}, hasOptions: true}},
+		{value: "name,2", want: keySpec{startChar: 1, startName: "name", endField: 2}},
+		{value: ":n", wantErr: true},
+		{value: "price:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandShortFlags(t *testing.T) {
+	flags := flag.NewFlagSet("sort", flag.ContinueOnError)
+	for _, name := range []string{"k", "t", "S"} {
+		flags.String(name, "", "")
+	}
+	for _, name := range []string{"n", "r", "csv"} {
+		flags.Bool(name, false, "")
+	}
+
+	got := expandShortFlags(flags, []string{"-k2,2n", "-nr", "-t:", "-k", "1", "-S=1M", "-csv", "-t", "-", "file", "--", "-kx"})
+	want := []string{"-k", "2,2n", "-n", "-r", "-t", ":", "-k", "1", "-S=1M", "-csv", "-t", "-", "file", "--", "-kx"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandShortFlags() = %q, want %q", got, want)
+	}
+}
+
+// Ожидаемые результаты получены из GNU sort с LC_ALL=C
+func Test_doSort_keys(t *testing.T) {
+	mustKeys := func(values ...string) []keySpec {
+		var keys []keySpec
+		for _, v := range values {
+			key, err := parseKey(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	lines := []string{"b 2 x", "a 10 y", "c 2 a", "d  3 z", "e\t1 q"}
+
+	tests := []struct {
+		name   string
+		data   []string
+		params parameters
+		want   []string
+	}{
+		{
+			name:   "chain of keys with own modifiers",
+			data:   lines,
+			params: parameters{keys: mustKeys("2,2n", "1,1r")},
+			want:   []string{"e\t1 q", "c 2 a", "b 2 x", "d  3 z", "a 10 y"},
+		},
+		{
+			name:   "leading blanks are part of the field",
+			data:   lines,
+			params: parameters{keys: mustKeys("2,2")},
+			want:   []string{"e\t1 q", "d  3 z", "a 10 y", "b 2 x", "c 2 a"},
+		},
+		{
+			name:   "global -b",
+			data:   lines,
+			params: parameters{keys: mustKeys("2,2"), skipBlanks: true},
+			want:   []string{"e\t1 q", "a 10 y", "b 2 x", "c 2 a", "d  3 z"},
+		},
+		{
+			name:   "separator with empty field",
+			data:   []string{"x:b:3", "y:a:3", "z:a:1", "w::2"},
+			params: parameters{keys: mustKeys("2,2", "3n"), separator: ':'},
+			want:   []string{"w::2", "z:a:1", "y:a:3", "x:b:3"},
+		},
+		{
+			name:   "character offset",
+			data:   []string{"ab12", "az03", "ba11"},
+			params: parameters{keys: mustKeys("1.3n")},
+			want:   []string{"az03", "ba11", "ab12"},
+		},
+		{
+			name:   "character range",
+			data:   []string{"foo bxz", "bar axa", "baz axb"},
+			params: parameters{keys: mustKeys("2.2,2.2", "1,1")},
+			want:   []string{"bar axa", "baz axb", "foo bxz"},
+		},
+		{
+			name:   "character offset counts runes",
+			data:   []string{"юб2", "яа1"},
+			params: parameters{keys: mustKeys("1.3")},
+			want:   []string{"яа1", "юб2"},
+		},
+		{
+			name:   "numeric edge cases",
+			data:   []string{"-1.5", "1e3", "10", "-0", "0", "007", ".5", "abc", "-"},
+			params: parameters{byNumeric: true},
+			want:   []string{"-1.5", "-", "-0", "0", "abc", ".5", "1e3", "007", "10"},
+		},
+		{
+			name:   "numeric beyond int64",
+			data:   []string{"123456789012345678901234567890", "-99999999999999999999", "9"},
+			params: parameters{byNumeric: true},
+			want:   []string{"-99999999999999999999", "9", "123456789012345678901234567890"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doSort(append([]string{}, tt.data...), tt.params)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doSort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ожидаемые результаты получены GNU sort с LC_ALL=C
+func Test_doSort_modes(t *testing.T) {
+	mustKeys := func(values ...string) []keySpec {
+		var keys []keySpec
+		for _, v := range values {
+			key, err := parseKey(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name   string
+		data   []string
+		params parameters
+		want   []string
+	}{
+		{
+			name:   "human sizes",
+			data:   []string{"2.5M", "1K", "3G", "100", "-1K", "0.5K", "1k"},
+			params: parameters{byHuman: true},
+			want:   []string{"-1K", "100", "0.5K", "1K", "1k", "2.5M", "3G"},
+		},
+		{
+			name:   "months",
+			data:   []string{"mar", "Feb", " JAN", "xyz", "december"},
+			params: parameters{byMonth: true},
+			want:   []string{"xyz", " JAN", "Feb", "mar", "december"},
+		},
+		{
+			name:   "versions",
+			data:   []string{"1.10", "1.2.10", "1.2", "v1.2", "1.2a", "1.2.9", "1.02", "1.2~rc1"},
+			params: parameters{byVersion: true},
+			want:   []string{"1.2~rc1", "1.02", "1.2", "1.2a", "1.2.9", "1.2.10", "1.10", "v1.2"},
+		},
+		{
+			name:   "general numbers",
+			data:   []string{"1e3", "-inf", "nan", "2.5", "abc", "-1e-2", "inf", "0x10"},
+			params: parameters{byGeneral: true},
+			want:   []string{"abc", "nan", "-inf", "-1e-2", "2.5", "0x10", "1e3", "inf"},
+		},
+		{
+			name:   "fold case",
+			data:   []string{"b", "B", "a", "A", "_"},
+			params: parameters{foldCase: true},
+			want:   []string{"A", "a", "B", "b", "_"},
+		},
+		{
+			name:   "modes per key",
+			data:   []string{"1K a", "2K b", "1K B", "3 c"},
+			params: parameters{keys: mustKeys("2,2f", "1,1h")},
+			want:   []string{"1K a", "1K B", "2K b", "3 c"},
+		},
+		{
+			name:   "reversed human key",
+			data:   []string{"x 1K", "y 1M", "z 5"},
+			params: parameters{keys: mustKeys("2,2hr")},
+			want:   []string{"y 1M", "x 1K", "z 5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doSort(append([]string{}, tt.data...), tt.params)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doSort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseKey("1,1nh"); err == nil {
+		t.Error("parseKey(\"1,1nh\") succeeded, want incompatible options error")
+	}
+}
+
+func Test_doSort_stable(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []string
+		params parameters
+		want   []string
+	}{
+		{
+			name:   "fold case keeps input order",
+			data:   []string{"b", "B", "a", "A"},
+			params: parameters{foldCase: true, isStable: true},
+			want:   []string{"a", "A", "b", "B"},
+		},
+		{
+			name:   "reverse keeps input order of equal keys",
+			data:   []string{"a 1", "b 1", "c 2"},
+			params: parameters{keys: columnKeys(2), isReverse: true, isStable: true},
+			want:   []string{"c 2", "a 1", "b 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doSort(append([]string{}, tt.data...), tt.params)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doSort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parallelSort_sameAsSequential(t *testing.T) {
+	var data []string
+	for i := 0; i < 5000; i++ {
+		data = append(data, fmt.Sprintf("%d line%d", (i*7919)%113, i))
+	}
+
+	for _, params := range []parameters{
+		{},
+		{keys: columnKeys(1), byNumeric: true, isStable: true},
+		{keys: columnKeys(1), isReverse: true, isStable: true},
+	} {
+		want := doSort(append([]string{}, data...), params)
+		for _, jobs := range []int{2, 3, 8} {
+			got := parallelSort(append([]string{}, data...), jobs, newComparator(params).less, params.isStable)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("params %+v, jobs %d: parallel sort differs from sequential", params, jobs)
+			}
+		}
+	}
+}
+
+func Test_checkSorted(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		params  parameters
+		wantNum int
+	}{
+		{name: "sorted", content: "a\nb\nb\nc\n"},
+		{name: "disorder", content: "a\nc\nb\n", wantNum: 3},
+		{name: "duplicates with -u", content: "a\nb\nb\n", params: parameters{isUnique: true}, wantNum: 3},
+		{name: "numeric", content: "2\n10\n9\n", params: parameters{byNumeric: true}, wantNum: 3},
+		{name: "reverse", content: "c\nb\na\n", params: parameters{isReverse: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := t.TempDir() + "/input.txt"
+			if err := os.WriteFile(name, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkSorted(name, tt.params)
+			var d *disorder
+			switch {
+			case tt.wantNum == 0 && err != nil:
+				t.Errorf("checkSorted() = %v, want nil", err)
+			case tt.wantNum != 0 && !errors.As(err, &d):
+				t.Errorf("checkSorted() = %v, want disorder", err)
+			case tt.wantNum != 0 && d.num != tt.wantNum:
+				t.Errorf("disorder at line %d, want %d", d.num, tt.wantNum)
+			}
+		})
+	}
+}
+
+func Test_mergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "a 1\nb 1\nc 2\n", "b.txt": "a 0\nb 3\n"}
+	var names []string
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, dir+"/"+name)
+	}
+
+	got := &bytes.Buffer{}
+	if err := mergeFiles(got, names, parameters{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a 0\na 1\nb 1\nb 3\nc 2\n"; got.String() != want {
+		t.Errorf("mergeFiles() = %q, want %q", got.String(), want)
+	}
+
+	// -m с файлами больше fanIn: промежуточные runs, входные файлы не удаляются.
+	// Ключ - первое поле, поэтому строки с равным ключом идут в порядке файлов
+	names = nil
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("%s/m%d.txt", dir, i)
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("a %d\nz %d\n", i, i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	sorter := newExternalSorter(parameters{tmpDir: t.TempDir(), keys: columnKeys(1)})
+	sorter.fanIn = 2
+	got.Reset()
+	if err := sorter.merge(got, names, false); err != nil {
+		t.Fatal(err)
+	}
+	sorter.cleanup()
+	if want := "a 0\na 1\na 2\na 3\na 4\nz 0\nz 1\nz 2\nz 3\nz 4\n"; got.String() != want {
+		t.Errorf("merge() = %q, want %q", got.String(), want)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("input file removed: %s", err)
+		}
+	}
+}
+
+func Test_doSort_locale(t *testing.T) {
+	data := []string{"яблоко", "Ёлка", "Андрей", "ёж", "Борис", "елена", "Ежов", "апельсин", "Яна"}
+
+	got := doSort(append([]string{}, data...), parameters{locale: "ru_RU.UTF-8"})
+	want := []string{"Андрей", "апельсин", "Борис", "ёж", "Ежов", "елена", "Ёлка", "яблоко", "Яна"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("doSort() = %q, want %q", got, want)
+	}
+
+	got = doSort(append([]string{}, data...), parameters{})
+	want = []string{"Ёлка", "Андрей", "Борис", "Ежов", "Яна", "апельсин", "елена", "яблоко", "ёж"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("without locale doSort() = %q, want byte order %q", got, want)
+	}
+
+	if _, err := newCollation("no-such-locale!"); err == nil {
+		t.Error("newCollation() succeeded for a bad locale")
+	}
+}
+
+func Test_createOutput_inPlace(t *testing.T) {
+	name := t.TempDir() + "/data.txt"
+	if err := os.WriteFile(name, []byte("c\na\nb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sorter := newExternalSorter(parameters{})
+	if err := readLines([]string{name}, sorter.add); err != nil {
+		t.Fatal(err)
+	}
+	out, err := createOutput(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sorter.output(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a\nb\nc\n" {
+		t.Errorf("file content = %q, want sorted", got)
+	}
+	if info, _ := os.Stat(name); info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func Test_sortCSVFiles(t *testing.T) {
+	const prices = "name,price,note\n" +
+		"\"Smith, J\",10.5,\"multi\nline\"\n" +
+		"Adams,9,ok\n" +
+		"Brown,100,\"say \"\"hi\"\"\"\n" +
+		"Adams,2,dup\n"
+
+	mustKeys := func(values ...string) []keySpec {
+		var keys []keySpec
+		for _, v := range values {
+			key, err := parseKey(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		params  parameters
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "numeric column by name",
+			files:  []string{prices},
+			params: parameters{keys: mustKeys("price:n")},
+			want: "name,price,note\n" +
+				"Adams,2,dup\n" +
+				"Adams,9,ok\n" +
+				"\"Smith, J\",10.5,\"multi\nline\"\n" +
+				"Brown,100,\"say \"\"hi\"\"\"\n",
+		},
+		{
+			name:   "chain of named keys",
+			files:  []string{prices},
+			params: parameters{keys: mustKeys("name", "price:nr")},
+			want: "name,price,note\n" +
+				"Adams,9,ok\n" +
+				"Adams,2,dup\n" +
+				"Brown,100,\"say \"\"hi\"\"\"\n" +
+				"\"Smith, J\",10.5,\"multi\nline\"\n",
+		},
+		{
+			name:   "unique by column number",
+			files:  []string{prices},
+			params: parameters{keys: columnKeys(1), isUnique: true},
+			want: "name,price,note\n" +
+				"Adams,9,ok\n" +
+				"Brown,100,\"say \"\"hi\"\"\"\n" +
+				"\"Smith, J\",10.5,\"multi\nline\"\n",
+		},
+		{
+			name:   "several files share the header",
+			files:  []string{"id,v\n3,c\n1,a\n", "id,v\n2,b\n"},
+			params: parameters{keys: mustKeys("id:n")},
+			want:   "id,v\n1,a\n2,b\n3,c\n",
+		},
+		{
+			name:   "empty first file",
+			files:  []string{"", "id,v\n2,b\n1,a\n", "id,v\n3,c\n"},
+			params: parameters{keys: mustKeys("id:n")},
+			want:   "id,v\n1,a\n2,b\n3,c\n",
+		},
+		{
+			name:   "tsv",
+			files:  []string{"a\tb\n2\tx y\n1\ty\n"},
+			params: parameters{separator: '\t', keys: mustKeys("a:n")},
+			want:   "a\tb\n1\ty\n2\tx y\n",
+		},
+		{
+			name:    "unknown column",
+			files:   []string{prices},
+			params:  parameters{keys: mustKeys("cost:n")},
+			wantErr: true,
+		},
+		{
+			name:    "different headers",
+			files:   []string{"id,v\n1,a\n", "v,id\nb,2\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			params.csv = true
+			if params.separator == 0 {
+				params.separator = ','
+			}
+			dir := t.TempDir()
+			for i, content := range tt.files {
+				name := filepath.Join(dir, fmt.Sprintf("%d.csv", i))
+				if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				params.filenames = append(params.filenames, name)
+			}
+
+			got := &bytes.Buffer{}
+			err := sortCSVFiles(got, params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sortCSVFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("sortCSVFiles() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// compareFolded сравнивает строки, приводя строчные буквы к заглавным (-f)
+func compareFolded(lhs, rhs string) int {
+	for lhs != "" && rhs != "" {
+		lr, lsize := utf8.DecodeRuneInString(lhs)
+		rr, rsize := utf8.DecodeRuneInString(rhs)
+		lr, rr = unicode.ToUpper(lr), unicode.ToUpper(rr)
+		if lr != rr {
+			if lr < rr {
+				return -1
+			}
+			return 1
+		}
+		lhs, rhs = lhs[lsize:], rhs[rsize:]
+	}
+	return len(lhs) - len(rhs)
+}
+
+// Порядок суффиксов для -h: число без суффикса меньше любого числа с суффиксом
+const humanSuffixes = "KMGTPEZYRQ"
+
+func humanUnit(s string) int {
+	if s == "" {
+		return 0
+	}
+	if s[0] == 'k' {
+		return 1
+	}
+	return strings.IndexByte(humanSuffixes, s[0]) + 1
+}
+
+// compareHuman сравнивает размеры вида 1K, 2.5M, 3G (-h): сначала знак, затем суффикс, затем число
+func compareHuman(lhs, rhs string) int {
+	lneg, lint, lfrac, lrest := parseNumber(lhs)
+	rneg, rint, rfrac, rrest := parseNumber(rhs)
+	lzero, rzero := lint == "" && lfrac == "", rint == "" && rfrac == ""
+
+	lsign, rsign := sign(lneg, lzero), sign(rneg, rzero)
+	if lsign != rsign {
+		return compareInts(lsign, rsign)
+	}
+
+	if res := compareInts(humanUnit(lrest), humanUnit(rrest)); res != 0 {
+		if lsign < 0 {
+			return -res
+		}
+		return res
+	}
+	return compareNumeric(lhs, rhs)
+}
+
+func sign(negative, zero bool) int {
+	switch {
+	case zero:
+		return 0
+	case negative:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func compareInts(lhs, rhs int) int {
+	switch {
+	case lhs < rhs:
+		return -1
+	case lhs > rhs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+var months = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// monthIndex - номер месяца по первым трём буквам (1..12), 0 - не месяц
+func monthIndex(s string) int {
+	s = strings.TrimLeft(s, " \t")
+	if len(s) < 3 {
+		return 0
+	}
+	prefix := strings.ToUpper(s[:3])
+	for i, m := range months {
+		if prefix == m {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// compareMonth - сравнение названий месяцев (-M), всё, что не месяц, идёт раньше января
+func compareMonth(lhs, rhs string) int {
+	return compareInts(monthIndex(lhs), monthIndex(rhs))
+}
+
+// versionOrder - вес символа при сравнении версий: "~" раньше конца строки,
+// буквы раньше остальных символов
+func versionOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case '0' <= c && c <= '9':
+		return 0
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// compareVersion сравнивает номера версий (-V): последовательности цифр - как числа,
+// остальные части - посимвольно (как filevercmp в GNU coreutils)
+func compareVersion(lhs, rhs string) int {
+	i, j := 0, 0
+	for i < len(lhs) || j < len(rhs) {
+		// нецифровые части
+		for (i < len(lhs) && !isDigit(lhs[i])) || (j < len(rhs) && !isDigit(rhs[j])) {
+			lc, rc := 0, 0
+			if i < len(lhs) {
+				lc = versionOrder(lhs[i])
+			}
+			if j < len(rhs) {
+				rc = versionOrder(rhs[j])
+			}
+			if lc != rc {
+				return compareInts(lc, rc)
+			}
+			i++
+			j++
+		}
+
+		// числовые части, ведущие нули не учитываются
+		for i < len(lhs) && lhs[i] == '0' {
+			i++
+		}
+		for j < len(rhs) && rhs[j] == '0' {
+			j++
+		}
+		li, rj := i, j
+		for i < len(lhs) && isDigit(lhs[i]) {
+			i++
+		}
+		for j < len(rhs) && isDigit(rhs[j]) {
+			j++
+		}
+		if res := compareInts(i-li, j-rj); res != 0 {
+			return res
+		}
+		if res := strings.Compare(lhs[li:i], rhs[rj:j]); res != 0 {
+			return res
+		}
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+var generalNumber = regexp.MustCompile(`^[ \t]*[-+]?(?:0[xX][0-9a-fA-F]+|(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?|(?i:inf(?:inity)?|nan))`)
+
+// parseGeneral разбирает число с плавающей точкой в начале строки, ok == false - числа нет
+func parseGeneral(s string) (value float64, ok bool) {
+	prefix := strings.TrimLeft(generalNumber.FindString(s), " \t")
+	if prefix == "" {
+		return 0, false
+	}
+	unsigned := strings.TrimLeft(prefix, "+-")
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0X") {
+		n, err := strconv.ParseUint(unsigned[2:], 16, 64)
+		value = float64(n)
+		if err != nil {
+			value = math.Inf(1)
+		}
+		if prefix[0] == '-' {
+			value = -value
+		}
+		return value, true
+	}
+	// при переполнении ParseFloat возвращает бесконечность, это и нужно
+	value, err := strconv.ParseFloat(prefix, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return value, true
+}
+
+// compareGeneral - сравнение чисел с плавающей точкой (-g): сначала не-числа, затем NaN,
+// затем числа по возрастанию, включая бесконечности
+func compareGeneral(lhs, rhs string) int {
+	lv, lok := parseGeneral(lhs)
+	rv, rok := parseGeneral(rhs)
+	rank := func(v float64, ok bool) int {
+		switch {
+		case !ok:
+			return 0
+		case math.IsNaN(v):
+			return 1
+		default:
+			return 2
+		}
+	}
+	if res := compareInts(rank(lv, lok), rank(rv, rok)); res != 0 || rank(lv, lok) < 2 {
+		return res
+	}
+	switch {
+	case lv < rv:
+		return -1
+	case lv > rv:
+		return 1
+	default:
+		return 0
+	}
+}
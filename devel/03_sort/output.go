@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// outputFile - место для результата: стандартный вывод или файл -o. Файл пишется во временный
+// рядом с ним и заменяет его только после успешной записи, поэтому "sort -o f f" безопасен
+type outputFile struct {
+	io.Writer
+	file *os.File
+	name string
+}
+
+func createOutput(name string) (*outputFile, error) {
+	if name == "" {
+		return &outputFile{Writer: os.Stdout}, nil
+	}
+
+	// права заменяемого файла сохраняются
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(name), ".sort-")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &outputFile{Writer: file, file: file, name: name}, nil
+}
+
+// commit заменяет файл -o записанным результатом
+func (o *outputFile) commit() error {
+	if o.file == nil {
+		return nil
+	}
+	if err := o.file.Close(); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	return os.Rename(o.file.Name(), o.name)
+}
+
+// abort удаляет недописанный результат, файл -o остаётся прежним
+func (o *outputFile) abort() {
+	if o.file == nil {
+		return
+	}
+	o.file.Close()
+	os.Remove(o.file.Name())
+}
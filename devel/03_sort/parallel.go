@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Меньше этого числа строк на горутину параллельная сортировка не окупается
+const minParallelLines = 1 << 14
+
+// sortLines сортирует срез на месте; с -s равные строки сохраняют порядок входа.
+// Строки - это строки текста или записи CSV
+func sortLines[T any](data []T, less func(T, T) bool, stable bool) {
+	if stable {
+		sort.SliceStable(data, func(i, j int) bool { return less(data[i], data[j]) })
+		return
+	}
+	sort.Slice(data, func(i, j int) bool { return less(data[i], data[j]) })
+}
+
+// parallelSort делит данные на jobs частей, сортирует их одновременно и сливает попарно,
+// тоже параллельно. При равенстве слияние берёт строку из левой части, поэтому
+// с -s результат совпадает с последовательной стабильной сортировкой
+func parallelSort[T any](data []T, jobs int, less func(T, T) bool, stable bool) []T {
+	jobs = min(jobs, len(data))
+	if jobs <= 1 {
+		sortLines(data, less, stable)
+		return data
+	}
+
+	parts := make([][]T, jobs)
+	var wg sync.WaitGroup
+	for i := range parts {
+		parts[i] = data[i*len(data)/jobs : (i+1)*len(data)/jobs]
+		wg.Add(1)
+		go func(part []T) {
+			defer wg.Done()
+			sortLines(part, less, stable)
+		}(parts[i])
+	}
+	wg.Wait()
+
+	for len(parts) > 1 {
+		merged := make([][]T, (len(parts)+1)/2)
+		for i := range merged {
+			if 2*i+1 == len(parts) {
+				merged[i] = parts[2*i]
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				merged[i] = mergeSorted(parts[2*i], parts[2*i+1], less)
+			}(i)
+		}
+		wg.Wait()
+		parts = merged
+	}
+	return parts[0]
+}
+
+// mergeSorted сливает два отсортированных среза в новый
+func mergeSorted[T any](lhs, rhs []T, less func(T, T) bool) []T {
+	res := make([]T, 0, len(lhs)+len(rhs))
+	for len(lhs) > 0 && len(rhs) > 0 {
+		if less(rhs[0], lhs[0]) {
+			res = append(res, rhs[0])
+			rhs = rhs[1:]
+		} else {
+			res = append(res, lhs[0])
+			lhs = lhs[1:]
+		}
+	}
+	res = append(res, lhs...)
+	return append(res, rhs...)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// annoDict собирает множества анаграмм потоково, по одному слову
+type annoDict struct {
+	fold cases.Caser
+	// уже встреченные слова (после нормализации)
+	seen map[string]struct{}
+	// ключ из букв слова -> множество
+	groups map[string]*annoGroup
+	// множества в порядке появления первого слова
+	order []*annoGroup
+}
+
+// annoGroup - множество анаграмм; first - первое встретившееся слово
+type annoGroup struct {
+	first string
+	words []string
+}
+
+func newAnnoDictBuilder() *annoDict {
+	return &annoDict{
+		fold:   cases.Fold(),
+		seen:   make(map[string]struct{}),
+		groups: make(map[string]*annoGroup),
+	}
+}
+
+// normalize приводит слово к NFC и нижнему регистру: "ПЯТАК" и "пятак", а также "й" одним
+// символом и "и" + U+0306 считаются одним словом
+func normalize(fold cases.Caser, word string) string {
+	return fold.String(norm.NFC.String(strings.TrimSpace(word)))
+}
+
+// add добавляет слово; повторы одного слова игнорируются
+func (d *annoDict) add(word string) {
+	word = normalize(d.fold, word)
+	if word == "" {
+		return
+	}
+	if _, ok := d.seen[word]; ok {
+		return
+	}
+	d.seen[word] = struct{}{}
+
+	key := convertStringToKey(word)
+	group, ok := d.groups[key]
+	if !ok {
+		group = &annoGroup{first: word}
+		d.groups[key] = group
+		d.order = append(d.order, group)
+	}
+	group.words = append(group.words, word)
+}
+
+// readFrom добавляет все слова из r (разделённые пробелами или переводами строк)
+func (d *annoDict) readFrom(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		d.add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// result возвращает множества из двух и более слов в порядке появления, слова в них отсортированы
+func (d *annoDict) result() []annoGroup {
+	result := make([]annoGroup, 0, len(d.order))
+	for _, group := range d.order {
+		if len(group.words) <= 1 {
+			continue
+		}
+		words := slices.Clone(group.words)
+		slices.Sort(words)
+		result = append(result, annoGroup{first: group.first, words: words})
+	}
+	return result
+}
+
+// runeCount - буква и число её повторов в слове
+type runeCount struct {
+	r rune
+	n int
+}
+
+// countRunes считает буквы слова, результат упорядочен по букве. Различных букв в слове
+// обычно немного, поэтому вставка в отсортированный срез быстрее map и сортировки всех рун
+func countRunes(word string, counts []runeCount) []runeCount {
+	for _, r := range word {
+		i, found := slices.BinarySearchFunc(counts, r, func(c runeCount, r rune) int {
+			return int(c.r - r)
+		})
+		if found {
+			counts[i].n++
+			continue
+		}
+		counts = slices.Insert(counts, i, runeCount{r: r, n: 1})
+	}
+	return counts
+}
+
+// convertStringToKey - ключ множества: буквы слова по возрастанию, с повторами
+func convertStringToKey(word string) string {
+	var buf [32]runeCount
+	var b strings.Builder
+	b.Grow(len(word))
+	for _, c := range countRunes(word, buf[:0]) {
+		for range c.n {
+			b.WriteRune(c.r)
+		}
+	}
+	return b.String()
+}
@@ -1,3 +1,5 @@
 module anno
 
 go 1.23.3
+
+require golang.org/x/text v0.21.0
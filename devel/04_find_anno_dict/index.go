@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+)
+
+// blank - в запросе обозначает любую одну букву
+const blank = '?'
+
+// indexVersion меняется при несовместимом изменении формата файла индекса
+const indexVersion = 1
+
+// annoIndex - словарь, разложенный по ключам convertStringToKey, для запросов:
+// анаграммы запроса, слова из букв запроса (sub-anagrams), в обоих случаях с blank
+type annoIndex struct {
+	// множества по возрастанию длины ключа, внутри длины - по ключу
+	entries []indexEntry
+	// ключ -> номер в entries
+	byKey map[string]int
+}
+
+type indexEntry struct {
+	key string
+	// длина ключа в символах
+	runes int
+	// буквы ключа, по биту на букву (по модулю 64): быстро отсекает ключи с буквами не из запроса
+	mask uint64
+	// слова множества по возрастанию
+	words []string
+}
+
+func newIndexEntry(key string, words []string) indexEntry {
+	entry := indexEntry{key: key, words: words}
+	for _, r := range key {
+		entry.runes++
+		entry.mask |= letterBit(r)
+	}
+	return entry
+}
+
+func letterBit(r rune) uint64 {
+	return 1 << (uint32(r) % 64)
+}
+
+// newAnnoIndex строит индекс по всем словам словаря, включая множества из одного слова.
+// Слова с blank пропускаются: в запросе '?' означает любую букву, и найти их было бы нельзя
+func newAnnoIndex(d *annoDict) *annoIndex {
+	entries := make([]indexEntry, 0, len(d.groups))
+	for key, group := range d.groups {
+		if strings.ContainsRune(key, blank) {
+			continue
+		}
+		words := slices.Clone(group.words)
+		slices.Sort(words)
+		entries = append(entries, newIndexEntry(key, words))
+	}
+	return newAnnoIndexFromEntries(entries)
+}
+
+func newAnnoIndexFromEntries(entries []indexEntry) *annoIndex {
+	slices.SortFunc(entries, func(a, b indexEntry) int {
+		return cmp.Or(cmp.Compare(a.runes, b.runes), strings.Compare(a.key, b.key))
+	})
+	index := &annoIndex{entries: entries, byKey: make(map[string]int, len(entries))}
+	for i, entry := range entries {
+		index.byKey[entry.key] = i
+	}
+	return index
+}
+
+// query - разобранный запрос: ключ из букв и число blank
+type query struct {
+	key    string
+	runes  int
+	mask   uint64
+	blanks int
+}
+
+func parseQuery(s string) query {
+	s = normalize(cases.Fold(), s)
+	var q query
+	letters := make([]rune, 0, len(s))
+	for _, r := range s {
+		if r == blank {
+			q.blanks++
+			continue
+		}
+		letters = append(letters, r)
+	}
+	q.key = convertStringToKey(string(letters))
+	q.runes = len(letters)
+	for _, r := range letters {
+		q.mask |= letterBit(r)
+	}
+	return q
+}
+
+// lengthRange - границы множеств с длиной ключа от min до max включительно
+func (ix *annoIndex) lengthRange(min, max int) (int, int) {
+	from, _ := slices.BinarySearchFunc(ix.entries, min, func(e indexEntry, n int) int {
+		return cmp.Compare(e.runes, n)
+	})
+	to, _ := slices.BinarySearchFunc(ix.entries, max+1, func(e indexEntry, n int) int {
+		return cmp.Compare(e.runes, n)
+	})
+	return from, to
+}
+
+// anagrams возвращает слова словаря, составленные ровно из букв запроса
+func (ix *annoIndex) anagrams(s string) []string {
+	q := parseQuery(s)
+	if q.blanks == 0 {
+		if i, ok := ix.byKey[q.key]; ok {
+			return slices.Clone(ix.entries[i].words)
+		}
+		return nil
+	}
+
+	var result []string
+	from, to := ix.lengthRange(q.runes+q.blanks, q.runes+q.blanks)
+	for _, entry := range ix.entries[from:to] {
+		if q.accepts(entry) {
+			result = append(result, entry.words...)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// subAnagrams возвращает слова словаря, которые можно составить из букв запроса (не обязательно
+// из всех): сначала длинные, при равной длине - по возрастанию. limit > 0 ограничивает число слов
+func (ix *annoIndex) subAnagrams(s string, limit int) []string {
+	q := parseQuery(s)
+
+	var result []string
+	from, to := ix.lengthRange(1, q.runes+q.blanks)
+	// с конца - чтобы при limit остались самые длинные слова
+	for i := to - 1; i >= from; i-- {
+		entry := ix.entries[i]
+		// слов уже достаточно, а дальше только более короткие
+		if limit > 0 && len(result) >= limit && entry.runes < ix.entries[i+1].runes {
+			break
+		}
+		if q.accepts(entry) {
+			result = append(result, entry.words...)
+		}
+	}
+	slices.SortFunc(result, func(a, b string) int {
+		return cmp.Or(cmp.Compare(utf8.RuneCountInString(b), utf8.RuneCountInString(a)), strings.Compare(a, b))
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// accepts - можно ли составить ключ множества из букв запроса, заменив недостающие буквы на blank
+func (q query) accepts(entry indexEntry) bool {
+	if entry.runes > q.runes+q.blanks {
+		return false
+	}
+	missing := entry.mask &^ q.mask
+	if q.blanks == 0 && missing != 0 {
+		return false
+	}
+	if q.blanks > 0 && bits.OnesCount64(missing) > q.blanks {
+		return false
+	}
+
+	// оба ключа отсортированы: идём по ним одновременно и считаем буквы, которых нет в запросе
+	deficit := 0
+	key, have := entry.key, q.key
+	for len(key) > 0 {
+		r, size := utf8.DecodeRuneInString(key)
+		key = key[size:]
+		// буквы запроса меньше r этому ключу уже не нужны
+		h, hsize := utf8.DecodeRuneInString(have)
+		for len(have) > 0 && h < r {
+			have = have[hsize:]
+			h, hsize = utf8.DecodeRuneInString(have)
+		}
+		if len(have) > 0 && h == r {
+			have = have[hsize:]
+			continue
+		}
+		if deficit++; deficit > q.blanks {
+			return false
+		}
+	}
+	return true
+}
+
+// savedIndex - формат файла индекса (gob)
+type savedIndex struct {
+	Version int
+	Keys    []string
+	Words   [][]string
+}
+
+// WriteTo сохраняет индекс в w
+func (ix *annoIndex) WriteTo(w io.Writer) (int64, error) {
+	saved := savedIndex{
+		Version: indexVersion,
+		Keys:    make([]string, len(ix.entries)),
+		Words:   make([][]string, len(ix.entries)),
+	}
+	for i, entry := range ix.entries {
+		saved.Keys[i] = entry.key
+		saved.Words[i] = entry.words
+	}
+
+	counter := &countingWriter{w: w}
+	err := gob.NewEncoder(counter).Encode(saved)
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readIndex загружает индекс, сохранённый WriteTo
+func readIndex(r io.Reader) (*annoIndex, error) {
+	var saved savedIndex
+	if err := gob.NewDecoder(bufio.NewReader(r)).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	if saved.Version != indexVersion {
+		return nil, fmt.Errorf("read index: unsupported version %d, expected %d", saved.Version, indexVersion)
+	}
+	if len(saved.Keys) != len(saved.Words) {
+		return nil, fmt.Errorf("read index: %d keys for %d word sets", len(saved.Keys), len(saved.Words))
+	}
+
+	entries := make([]indexEntry, len(saved.Keys))
+	for i, key := range saved.Keys {
+		entries[i] = newIndexEntry(key, saved.Words[i])
+	}
+	return newAnnoIndexFromEntries(entries), nil
+}
+
+// saveIndexFile пишет индекс во временный файл рядом с name и переименовывает его,
+// чтобы прерванная запись не испортила прежний индекс
+func saveIndexFile(ix *annoIndex, name string) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if _, err = ix.WriteTo(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func loadIndexFile(name string) (*annoIndex, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readIndex(file)
+}
@@ -1,46 +1,195 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
 	"sort"
-	"strings"
 )
 
 func main() {
-	// тестируем через тестовые случаи
+	// пример: go run . words.txt; cat words.txt | go run . -json
+	// go run . index -o words.idx words.txt; go run . query -i words.idx -sub "пятак?"
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func newAnnoDict(words []string) map[string][]string {
-	dict := make(map[string][]string)
+// run без подкоманды читает словарь из файла или stdin и печатает множества анаграмм.
+// Подкоманды index, query и serve строят индекс словаря и отвечают на запросы по нему
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "index":
+			return runIndex(args[1:], stdin)
+		case "query":
+			return runQuery(args[1:], stdout)
+		case "serve":
+			return runServe(args[1:])
+		}
+	}
 
-	for _, word := range words {
-		word = strings.ToLower(word)
-		key := convertStringToKey(word)
-		if _, ok := dict[key]; !ok {
-			dict[key] = []string{word}
+	flags := flag.NewFlagSet("anno", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print groups as a JSON object")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: anno [-json] [FILE]")
+	}
+
+	dict, err := readDict(flags.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(stdout)
+	if *asJSON {
+		if err := writeJSON(out, dict.result()); err != nil {
+			return err
+		}
+	} else {
+		writeText(out, dict.result())
+	}
+	return out.Flush()
+}
+
+// readDict читает словарь из файла name, а если name пустое или "-" - из stdin
+func readDict(name string, stdin io.Reader) (*annoDict, error) {
+	in := stdin
+	if name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	dict := newAnnoDictBuilder()
+	if err := dict.readFrom(in); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// runIndex строит индекс словаря и сохраняет его в файл. Слова с '?' в индекс не попадают
+func runIndex(args []string, stdin io.Reader) error {
+	flags := flag.NewFlagSet("index", flag.ContinueOnError)
+	output := flags.String("o", "", "Index file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" || flags.NArg() > 1 {
+		return fmt.Errorf("usage: anno index -o INDEX [FILE]")
+	}
+
+	dict, err := readDict(flags.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	return saveIndexFile(newAnnoIndex(dict), *output)
+}
+
+// runQuery ищет по индексу слова для каждого запроса. В запросе '?' - любая буква
+func runQuery(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	input := flags.String("i", "", "Index file built by anno index")
+	sub := flags.Bool("sub", false, "Find words built from a subset of the letters")
+	limit := flags.Int("limit", 0, "Maximum number of words per query with -sub (0 - unlimited)")
+	asJSON := flags.Bool("json", false, "Print results as a JSON object")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: anno query -i INDEX [-sub] [-limit N] [-json] WORD...")
+	}
+
+	index, err := loadIndexFile(*input)
+	if err != nil {
+		return err
+	}
+
+	results := make([]annoGroup, 0, flags.NArg())
+	for _, q := range flags.Args() {
+		result := annoGroup{first: q}
+		if *sub {
+			result.words = nonNil(index.subAnagrams(q, *limit))
 		} else {
-			if !isStringInSet(word, dict[key]) {
-				dict[key] = append(dict[key], word)
-			}
+			result.words = nonNil(index.anagrams(q))
+		}
+		results = append(results, result)
+	}
+
+	out := bufio.NewWriter(stdout)
+	if *asJSON {
+		if err := writeJSON(out, results); err != nil {
+			return err
 		}
+	} else {
+		writeText(out, results)
 	}
+	return out.Flush()
+}
 
-	return sortMapValues(removeOneValueKeys(dict))
+// writeText печатает по множеству на строку: "пятак: пятак пятка тяпка"
+func writeText(w *bufio.Writer, groups []annoGroup) {
+	for _, group := range groups {
+		w.WriteString(group.first)
+		w.WriteByte(':')
+		for _, word := range group.words {
+			w.WriteByte(' ')
+			w.WriteString(word)
+		}
+		w.WriteByte('\n')
+	}
 }
 
-func isStringInSet(str string, strs []string) bool {
-	for _, v := range strs {
-		if v == str {
-			return true
+// writeJSON печатает объект {"пятак": ["пятак", "пятка", "тяпка"]}. Ключи идут в порядке
+// появления, поэтому объект собирается вручную, а не через map
+func writeJSON(w *bufio.Writer, groups []annoGroup) error {
+	w.WriteByte('{')
+	for i, group := range groups {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString("\n  ")
+		key, err := json.Marshal(group.first)
+		if err != nil {
+			return err
+		}
+		words, err := json.Marshal(group.words)
+		if err != nil {
+			return err
 		}
+		w.Write(key)
+		w.WriteString(": ")
+		w.Write(words)
 	}
-	return false
+	if len(groups) > 0 {
+		w.WriteByte('\n')
+	}
+	w.WriteString("}\n")
+	return nil
 }
 
-func convertStringToKey(word string) string {
-	letters := strings.Split(word, "")
-	sort.Strings(letters)
+// newAnnoDict возвращает множества анаграмм: ключ - первое встретившееся слово множества,
+// значение - все слова множества по возрастанию. Множества из одного слова не попадают в результат
+func newAnnoDict(words []string) map[string][]string {
+	dict := newAnnoDictBuilder()
+	for _, word := range words {
+		dict.add(word)
+	}
 
-	return strings.Join(letters, "")
+	result := make(map[string][]string)
+	for _, group := range dict.order {
+		result[group.first] = group.words
+	}
+	return sortMapValues(removeOneValueKeys(result))
 }
 
 func sortMapValues(dict map[string][]string) map[string][]string {
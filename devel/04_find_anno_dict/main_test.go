@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -17,22 +27,39 @@ func Test_newAnnoDict(t *testing.T) {
 		{
 			name: "values sorting",
 			args: args{words: []string{"тяпка", "пятка", "пятак"}},
-			want: map[string][]string{"акптя": {"пятак", "пятка", "тяпка"}},
+			want: map[string][]string{"тяпка": {"пятак", "пятка", "тяпка"}},
 		},
 		{
 			name: "Diff letter cases",
 			args: args{words: []string{"тЯпкА", "ПЯТКА", "пЯтАК"}},
-			want: map[string][]string{"акптя": {"пятак", "пятка", "тяпка"}},
+			want: map[string][]string{"тяпка": {"пятак", "пятка", "тяпка"}},
 		},
 		{
 			name: "With repeats",
 			args: args{words: []string{"тяпка", "пятка", "пятак", "тяпка", "пятка", "пятак"}},
-			want: map[string][]string{"акптя": {"пятак", "пятка", "тяпка"}},
+			want: map[string][]string{"тяпка": {"пятак", "пятка", "тяпка"}},
 		},
 		{
 			name: "Remove one line",
 			args: args{words: []string{"тяпка", "пятка", "пятак", "афанасий", "никитиН", "НИКИТИН"}},
-			want: map[string][]string{"акптя": {"пятак", "пятка", "тяпка"}},
+			want: map[string][]string{"тяпка": {"пятак", "пятка", "тяпка"}},
+		},
+		{
+			name: "Key is the first seen word",
+			args: args{words: []string{"листок", "пятка", "слиток", "пятак", "столик", "тяпка"}},
+			want: map[string][]string{
+				"листок": {"листок", "слиток", "столик"},
+				"пятка":  {"пятак", "пятка", "тяпка"},
+			},
+		},
+		{
+			name: "Unicode normalization",
+			// "йод" одним символом и "и" + U+0306, латиница в разном регистре
+			args: args{words: []string{"дой", "йод", "\u0438\u0306од", "Listen", "SILENT", "enlist"}},
+			want: map[string][]string{
+				"дой":    {"дой", "йод"},
+				"listen": {"enlist", "listen", "silent"},
+			},
 		},
 	}
 	for _, tt := range tests {
@@ -65,6 +92,10 @@ func Test_convertStringToKey(t *testing.T) {
 			args: args{word: "тяпка!"},
 			want: "!акптя",
 		},
+		{
+			args: args{word: "колокол"},
+			want: "ккллооо",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -98,3 +129,304 @@ func Test_sortMapValues(t *testing.T) {
 		})
 	}
 }
+
+func Test_run(t *testing.T) {
+	dict := "пятак\nЛистОк пятка\nкот\nТЯПКА слиток\nпятак\n"
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			args: []string{},
+			want: "пятак: пятак пятка тяпка\nлисток: листок слиток\n",
+		},
+		{
+			args: []string{"-json"},
+			want: "{\n  \"пятак\": [\"пятак\",\"пятка\",\"тяпка\"],\n  \"листок\": [\"листок\",\"слиток\"]\n}\n",
+		},
+		{args: []string{"-json", "a", "b"}, wantErr: true},
+		{args: []string{"no-such-file"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := run(tt.args, strings.NewReader(dict), &out)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("run(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got := out.String(); !tt.wantErr && got != tt.want {
+			t.Errorf("run(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"-json"}, strings.NewReader("кот"), &out); err != nil || out.String() != "{}\n" {
+		t.Errorf("run() on dictionary without anagrams = %q, %v", out.String(), err)
+	}
+}
+
+func newTestIndex(words ...string) *annoIndex {
+	dict := newAnnoDictBuilder()
+	for _, word := range words {
+		dict.add(word)
+	}
+	return newAnnoIndex(dict)
+}
+
+var testIndexWords = []string{"пятак", "пятка", "тяпка", "кот", "ток", "кто", "тк", "пакт", "кап", "па", "пятно", "Кот"}
+
+func Test_annoIndex_anagrams(t *testing.T) {
+	index := newTestIndex(testIndexWords...)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "КАТЯП", want: []string{"пятак", "пятка", "тяпка"}},
+		{query: "окт", want: []string{"кот", "кто", "ток"}},
+		{query: "ок", want: nil},
+		{query: "пят?к", want: []string{"пятак", "пятка", "тяпка"}},
+		{query: "пят??", want: []string{"пятак", "пятка", "пятно", "тяпка"}},
+		{query: "???", want: []string{"кап", "кот", "кто", "ток"}},
+		{query: "я?", want: nil},
+	}
+	for _, tt := range tests {
+		if got := index.anagrams(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("anagrams(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// Слова с '?' в индекс не попадают: '?' в запросе - любая буква
+func Test_annoIndex_skipsBlankWords(t *testing.T) {
+	index := newTestIndex("к?т", "кот")
+	if got, want := index.anagrams("к?т"), []string{"кот"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("anagrams(%q) = %q, want %q", "к?т", got, want)
+	}
+	if len(index.entries) != 1 {
+		t.Errorf("index has %d entries, want 1", len(index.entries))
+	}
+}
+
+func Test_annoIndex_subAnagrams(t *testing.T) {
+	index := newTestIndex(testIndexWords...)
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{query: "тока", want: []string{"кот", "кто", "ток", "тк"}},
+		{query: "пакт", want: []string{"пакт", "кап", "па", "тк"}},
+		{query: "пакт", limit: 2, want: []string{"пакт", "кап"}},
+		{query: "пакт", limit: 3, want: []string{"пакт", "кап", "па"}},
+		{query: "к?", want: []string{"тк"}},
+		{query: "я", want: nil},
+	}
+	for _, tt := range tests {
+		if got := index.subAnagrams(tt.query, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("subAnagrams(%q, %d) = %q, want %q", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// accepts сверяется с подсчётом букв через map
+func Test_query_accepts(t *testing.T) {
+	letters := []rune("абвaz?")
+	randomWord := func(r *rand.Rand) string {
+		word := make([]rune, r.Intn(6))
+		for i := range word {
+			word[i] = letters[r.Intn(len(letters))]
+		}
+		return string(word)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for range 20000 {
+		word := strings.ReplaceAll(randomWord(r), "?", "")
+		q := parseQuery(randomWord(r))
+
+		have := make(map[rune]int)
+		for _, c := range q.key {
+			have[c]++
+		}
+		deficit := 0
+		for _, c := range word {
+			if have[c] > 0 {
+				have[c]--
+			} else {
+				deficit++
+			}
+		}
+
+		entry := newIndexEntry(convertStringToKey(word), []string{word})
+		if got, want := q.accepts(entry), deficit <= q.blanks; got != want {
+			t.Fatalf("query %+v accepts(%q) = %v, want %v", q, word, got, want)
+		}
+	}
+}
+
+func Test_annoIndex_persist(t *testing.T) {
+	index := newTestIndex(testIndexWords...)
+	name := filepath.Join(t.TempDir(), "words.idx")
+	if err := saveIndexFile(index, name); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, index) {
+		t.Errorf("loaded index differs from saved one")
+	}
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(savedIndex{Version: indexVersion + 1})
+	if _, err := readIndex(&buf); err == nil {
+		t.Errorf("readIndex() accepted unsupported version")
+	}
+	if _, err := readIndex(strings.NewReader("not an index")); err == nil {
+		t.Errorf("readIndex() accepted garbage")
+	}
+}
+
+func Test_run_index(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "words.idx")
+	dict := strings.NewReader(strings.Join(testIndexWords, "\n"))
+	if err := run([]string{"index", "-o", name}, dict, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"query", "-i", name, "катяп", "ок"}, want: "катяп: пятак пятка тяпка\nок:\n"},
+		{args: []string{"query", "-i", name, "-sub", "-limit", "1", "-json", "пакт"}, want: "{\n  \"пакт\": [\"пакт\"]\n}\n"},
+		{args: []string{"query", "-i", name}, wantErr: true},
+		{args: []string{"query", "катяп"}, wantErr: true},
+		{args: []string{"index", "words.txt"}, wantErr: true},
+		{args: []string{"serve"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := run(tt.args, strings.NewReader(""), &out)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("run(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got := out.String(); !tt.wantErr && got != tt.want {
+			t.Errorf("run(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func Test_indexHandler(t *testing.T) {
+	handler := newIndexHandler(newTestIndex(testIndexWords...))
+	tests := []struct {
+		method string
+		target string
+		code   int
+		want   string
+	}{
+		{method: http.MethodGet, target: "/anagrams?q=" + url.QueryEscape("окт"), code: http.StatusOK, want: `{"result":["кот","кто","ток"]}`},
+		{method: http.MethodGet, target: "/anagrams?q=" + url.QueryEscape("я"), code: http.StatusOK, want: `{"result":[]}`},
+		{method: http.MethodGet, target: "/subanagrams?limit=1&q=" + url.QueryEscape("пакт?"), code: http.StatusOK, want: `{"result":["пятак"]}`},
+		{method: http.MethodGet, target: "/subanagrams?limit=-1&q=a", code: http.StatusBadRequest, want: `{"error":"limit must be between 1 and 1000"}`},
+		{method: http.MethodGet, target: "/subanagrams?limit=0&q=a", code: http.StatusBadRequest, want: `{"error":"limit must be between 1 and 1000"}`},
+		{method: http.MethodGet, target: "/subanagrams?limit=1001&q=a", code: http.StatusBadRequest, want: `{"error":"limit must be between 1 and 1000"}`},
+		{method: http.MethodGet, target: "/anagrams?limit=2&q=" + url.QueryEscape("???"), code: http.StatusOK, want: `{"result":["кап","кот"]}`},
+		{method: http.MethodGet, target: "/anagrams?q=" + strings.Repeat("a", 65), code: http.StatusBadRequest, want: `{"error":"q must be at most 64 characters"}`},
+		{method: http.MethodGet, target: "/anagrams", code: http.StatusBadRequest, want: `{"error":"missing query parameter q"}`},
+		{method: http.MethodPost, target: "/anagrams?q=a", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: code = %d, want %d", tt.method, tt.target, rec.Code, tt.code)
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.want != "" && got != tt.want {
+			t.Errorf("%s %s = %s, want %s", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+// Бенчмарки на словаре из миллиона случайных слов
+var (
+	benchWordsOnce sync.Once
+	benchWords     []string
+	benchIndex     *annoIndex
+)
+
+func benchmarkIndex(b *testing.B) *annoIndex {
+	benchWordsOnce.Do(func() {
+		letters := []rune("абвгдеёжзийклмнопрстуфхцчшщъыьэюя")
+		r := rand.New(rand.NewSource(1))
+		benchWords = make([]string, 1_000_000)
+		for i := range benchWords {
+			word := make([]rune, 3+r.Intn(10))
+			for j := range word {
+				word[j] = letters[r.Intn(len(letters))]
+			}
+			benchWords[i] = string(word)
+		}
+		benchIndex = newTestIndex(benchWords...)
+	})
+	return benchIndex
+}
+
+func Benchmark_newAnnoIndex(b *testing.B) {
+	benchmarkIndex(b)
+	b.ResetTimer()
+	for range b.N {
+		newTestIndex(benchWords...)
+	}
+}
+
+func Benchmark_readIndex(b *testing.B) {
+	var buf bytes.Buffer
+	if _, err := benchmarkIndex(b).WriteTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := readIndex(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_anagrams(b *testing.B) {
+	index := benchmarkIndex(b)
+	b.ResetTimer()
+	for i := range b.N {
+		index.anagrams(benchWords[i%len(benchWords)])
+	}
+}
+
+func Benchmark_anagrams_wildcard(b *testing.B) {
+	index := benchmarkIndex(b)
+	b.ResetTimer()
+	for range b.N {
+		index.anagrams("пят??к")
+	}
+}
+
+func Benchmark_subAnagrams(b *testing.B) {
+	index := benchmarkIndex(b)
+	b.ResetTimer()
+	for range b.N {
+		index.subAnagrams("переподготовка", 0)
+	}
+}
+
+func Benchmark_subAnagrams_wildcard(b *testing.B) {
+	index := benchmarkIndex(b)
+	b.ResetTimer()
+	for range b.N {
+		index.subAnagrams("перепод??", 0)
+	}
+}
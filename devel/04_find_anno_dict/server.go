@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Ограничения HTTP-запросов: число слов в ответе (по умолчанию и максимум) и длина q в символах.
+// С blank запрос перебирает весь индекс, а ответ без limit может содержать весь словарь
+const (
+	defaultHTTPLimit = 100
+	maxHTTPLimit     = 1000
+	maxQueryRunes    = 64
+)
+
+// runServe отвечает на запросы к индексу по HTTP:
+// GET /anagrams?q=пятак, GET /subanagrams?q=пятак?&limit=10
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	input := flags.String("i", "", "Index file built by anno index")
+	addr := flags.String("addr", ":8080", "Address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" || flags.NArg() > 0 {
+		return fmt.Errorf("usage: anno serve -i INDEX [-addr ADDR]")
+	}
+
+	index, err := loadIndexFile(*input)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           newIndexHandler(index),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("serving %s on %s", *input, *addr)
+	return server.ListenAndServe()
+}
+
+func newIndexHandler(index *annoIndex) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anagrams", func(w http.ResponseWriter, r *http.Request) {
+		q, limit, ok := parseIndexRequest(w, r)
+		if !ok {
+			return
+		}
+		words := index.anagrams(q)
+		if len(words) > limit {
+			words = words[:limit]
+		}
+		sendResponse(w, http.StatusOK, nonNil(words))
+	})
+	mux.HandleFunc("/subanagrams", func(w http.ResponseWriter, r *http.Request) {
+		q, limit, ok := parseIndexRequest(w, r)
+		if !ok {
+			return
+		}
+		sendResponse(w, http.StatusOK, nonNil(index.subAnagrams(q, limit)))
+	})
+	return mux
+}
+
+// parseIndexRequest достаёт из запроса q и limit; при ошибке сам отвечает клиенту и возвращает ok == false.
+// Без limit в запросе возвращается defaultHTTPLimit слов
+func parseIndexRequest(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return "", 0, false
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		sendError(w, http.StatusBadRequest, "missing query parameter q")
+		return "", 0, false
+	}
+	if utf8.RuneCountInString(q) > maxQueryRunes {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("q must be at most %d characters", maxQueryRunes))
+		return "", 0, false
+	}
+
+	limit := defaultHTTPLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxHTTPLimit {
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxHTTPLimit))
+			return "", 0, false
+		}
+		limit = n
+	}
+	return q, limit, true
+}
+
+// nonNil - пустой результат отдаём как [], а не null
+func nonNil(words []string) []string {
+	if words == nil {
+		return []string{}
+	}
+	return words
+}
+
+func sendResponse(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+
+	response := struct {
+		Result interface{} `json:"result"`
+	}{
+		Result: data,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func sendError(w http.ResponseWriter, code int, errText string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+
+	response := struct {
+		Error string `json:"error"`
+	}{
+		Error: errText,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+// ahoCorasick ищет сразу много фиксированных строк за один проход по тексту.
+// Автомат строится по байтам, поэтому позиции совпадений - байтовые смещения
+type ahoCorasick struct {
+	// classes переводит байт в номер столбца таблицы переходов. Байты, которых нет
+	// в шаблонах, попадают в класс 0, так таблица остаётся небольшой
+	classes  [256]byte
+	nClasses int
+	next     []int32
+	fail     []int32
+	// out[state] - длины шаблонов, заканчивающихся в этом состоянии (включая суффиксные по fail)
+	out [][]int
+}
+
+func newAhoCorasick(patterns []string, foldASCII bool) *ahoCorasick {
+	ac := &ahoCorasick{nClasses: 1}
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			c := p[i]
+			if foldASCII {
+				c = lowerASCII(c)
+			}
+			if ac.classes[c] == 0 {
+				ac.classes[c] = byte(ac.nClasses)
+				ac.nClasses++
+			}
+		}
+	}
+	if foldASCII {
+		for c := 'A'; c <= 'Z'; c++ {
+			ac.classes[c] = ac.classes[c+'a'-'A']
+		}
+	}
+
+	ac.addState()
+	for _, p := range patterns {
+		state := int32(0)
+		for i := 0; i < len(p); i++ {
+			idx := ac.index(state, p[i])
+			if ac.next[idx] == 0 {
+				s := ac.addState()
+				ac.next[idx] = s
+			}
+			state = ac.next[idx]
+		}
+		ac.out[state] = append(ac.out[state], len(p))
+	}
+
+	// Обход в ширину: строим fail-ссылки и сразу превращаем бор в полный автомат переходов
+	var queue []int32
+	for c := 1; c < ac.nClasses; c++ {
+		if s := ac.next[c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		fail := ac.fail[state]
+		ac.out[state] = append(ac.out[state], ac.out[fail]...)
+		for c := 1; c < ac.nClasses; c++ {
+			s := ac.next[int(state)*ac.nClasses+c]
+			if s == 0 {
+				ac.next[int(state)*ac.nClasses+c] = ac.next[int(fail)*ac.nClasses+c]
+				continue
+			}
+			ac.fail[s] = ac.next[int(fail)*ac.nClasses+c]
+			queue = append(queue, s)
+		}
+	}
+	return ac
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func (ac *ahoCorasick) addState() int32 {
+	ac.next = append(ac.next, make([]int32, ac.nClasses)...)
+	ac.fail = append(ac.fail, 0)
+	ac.out = append(ac.out, nil)
+	return int32(len(ac.fail) - 1)
+}
+
+func (ac *ahoCorasick) index(state int32, c byte) int {
+	return int(state)*ac.nClasses + int(ac.classes[c])
+}
+
+// contains - есть ли в тексте хотя бы один шаблон
+func (ac *ahoCorasick) contains(text string) bool {
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = ac.next[ac.index(state, text[i])]
+		if len(ac.out[state]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates возвращает все вхождения шаблонов, в том числе пересекающиеся, как пары [start, end)
+func (ac *ahoCorasick) candidates(text string) [][]int {
+	var res [][]int
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = ac.next[ac.index(state, text[i])]
+		for _, length := range ac.out[state] {
+			res = append(res, []int{i + 1 - length, i + 1})
+		}
+	}
+	return res
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Сколько байт из начала файла проверяется на признак двоичного файла (как в GNU grep)
+const binaryCheckSize = 8000
+
+// globList - повторяемый флаг со списком шаблонов (--include/--exclude)
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("bad glob %q: %w", value, err)
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// matchesAny проверяет имя файла (без каталога) на соответствие хотя бы одному шаблону
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncluded применяет --include/--exclude к имени файла
+func isIncluded(params parametres, name string) bool {
+	base := filepath.Base(name)
+	if len(params.include) > 0 && !matchesAny(params.include, base) {
+		return false
+	}
+	return !matchesAny(params.exclude, base)
+}
+
+// collectFiles раскрывает аргументы командной строки в список файлов в порядке обхода.
+// Каталоги обходятся только с -r, с учётом .gitignore. Ошибки по отдельным путям
+// передаются в onError и не прерывают обход
+func collectFiles(params parametres, onError func(error)) []string {
+	var files []string
+	for _, name := range params.filenames {
+		info, err := os.Stat(name)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if !info.IsDir() {
+			// Явно указанные файлы фильтруются так же, как и найденные при обходе
+			if isIncluded(params, name) {
+				files = append(files, name)
+			}
+			continue
+		}
+		if !params.recursive {
+			onError(fmt.Errorf("%s: Is a directory", name))
+			continue
+		}
+		files = append(files, walkDir(name, params, onError)...)
+	}
+	return files
+}
+
+func walkDir(root string, params parametres, onError func(error)) []string {
+	var files []string
+	ignores := newIgnoreStack()
+
+	filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			onError(err)
+			return nil
+		}
+
+		if d.IsDir() {
+			if name != root && (d.Name() == ".git" || ignores.isIgnored(name, true)) {
+				return filepath.SkipDir
+			}
+			ignores.enter(name, onError)
+			return nil
+		}
+
+		if !d.Type().IsRegular() || ignores.isIgnored(name, false) || !isIncluded(params, name) {
+			return nil
+		}
+		files = append(files, name)
+		return nil
+	})
+
+	return files
+}
+
+// isBinary проверяет начало потока на нулевой байт, не вычитывая данные из reader
+func isBinary(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(binaryCheckSize)
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// openFile открывает файл для поиска, распаковывая и перекодируя его при необходимости.
+// Для двоичных файлов возвращается skip == true
+func openFile(name string, params parametres) (reader io.Reader, closer io.Closer, skip bool, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	wrapped, wrapCloser, err := wrapInput(file, params)
+	if err != nil {
+		file.Close()
+		return nil, nil, false, fmt.Errorf("%s: %w", name, err)
+	}
+	closer = closerFunc(func() error {
+		return errors.Join(wrapCloser.Close(), file.Close())
+	})
+
+	buffered := bufio.NewReader(wrapped)
+	if isBinary(buffered) {
+		closer.Close()
+		return nil, nil, true, nil
+	}
+	return buffered, closer, false, nil
+}
+
+// ignoreRule - одна строка .gitignore
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreFile - правила одного .gitignore, действующие внутри каталога dir
+type ignoreFile struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// ignoreStack хранит .gitignore всех каталогов на текущем пути обхода
+type ignoreStack struct {
+	files []ignoreFile
+}
+
+func newIgnoreStack() *ignoreStack {
+	return &ignoreStack{}
+}
+
+// enter читает .gitignore каталога и убирает правила каталогов, из которых обход уже вышел
+func (s *ignoreStack) enter(dir string, onError func(error)) {
+	for len(s.files) > 0 && !isSubpath(s.files[len(s.files)-1].dir, dir) {
+		s.files = s.files[:len(s.files)-1]
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			onError(err)
+		}
+		return
+	}
+	s.files = append(s.files, ignoreFile{dir: dir, rules: parseGitignore(string(data))})
+}
+
+// isIgnored - побеждает последнее подходящее правило, правила вложенных каталогов приоритетнее
+func (s *ignoreStack) isIgnored(name string, isDir bool) bool {
+	ignored := false
+	for _, f := range s.files {
+		if !isSubpath(f.dir, name) {
+			continue
+		}
+		rel, err := filepath.Rel(f.dir, name)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, rule := range f.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func isSubpath(dir, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func parseGitignore(data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, text := range strings.Split(data, "\n") {
+		text = strings.TrimRight(text, "\r")
+		text = strings.TrimRight(text, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(text, "!") {
+			rule.negate = true
+			text = text[1:]
+		}
+		text = strings.TrimPrefix(text, `\`)
+		if strings.HasSuffix(text, "/") {
+			rule.dirOnly = true
+			text = strings.TrimSuffix(text, "/")
+		}
+
+		// Шаблон без "/" в середине действует на любой глубине, иначе - относительно каталога .gitignore
+		anchored := strings.Contains(text, "/")
+		text = strings.TrimPrefix(text, "/")
+
+		expr := globToRegexp(text)
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globToRegexp переводит шаблон gitignore (с поддержкой **) в регулярное выражение
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
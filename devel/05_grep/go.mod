@@ -1,3 +1,8 @@
 module grep
 
 go 1.23.3
+
+require (
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/text v0.21.0
+)
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+type line struct {
+	num int
+	// смещение начала строки от начала входа в байтах
+	offset int
+	text   string
+}
+
+// lineRing - кольцевой буфер последних строк для контекста -B
+type lineRing struct {
+	lines []line
+	start int
+	size  int
+}
+
+func newLineRing(capacity int) *lineRing {
+	return &lineRing{lines: make([]line, capacity)}
+}
+
+func (r *lineRing) push(l line) {
+	if len(r.lines) == 0 {
+		return
+	}
+	if r.size < len(r.lines) {
+		r.lines[(r.start+r.size)%len(r.lines)] = l
+		r.size++
+		return
+	}
+	// буфер полон - вытесняем самую старую строку
+	r.lines[r.start] = l
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+// drain возвращает накопленные строки в порядке поступления и очищает буфер
+func (r *lineRing) drain() []line {
+	res := make([]line, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		res = append(res, r.lines[(r.start+i)%len(r.lines)])
+	}
+	r.start, r.size = 0, 0
+	return res
+}
+
+// Имя, под которым стандартный ввод выводится в режимах -H/-l/-L
+const stdinName = "(standard input)"
+
+// doGrep обрабатывает стандартный ввод (или любой другой поток)
+func doGrep(in io.Reader, params parametres, out io.Writer) error {
+	m, err := newMatcher(params)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	writer := bufio.NewWriter(out)
+	stats, err := grepStream(in, stdinName, params, m, writer)
+	if err != nil {
+		return err
+	}
+	if params.json {
+		if err := writeJSONSummary(writer, stats, time.Since(start)); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// grepStream обрабатывает строки по мере поступления, не загружая вход целиком в память.
+// Строки контекста до совпадения хранятся в кольцевом буфере на -B строк,
+// после совпадения печатается -A строк. С -m NUM чтение прекращается после NUM совпадений
+// и их завершающего контекста. Возвращает статистику поиска
+func grepStream(in io.Reader, filename string, params parametres, m matcher, writer *bufio.Writer) (searchStats, error) {
+	checker := newChecker(m, params.invert)
+	reader := bufio.NewReader(in)
+	out := newPrinter(writer, params, m, filename)
+
+	// В режимах -l/-L нужен только факт совпадения, строки не печатаются
+	listOnly := params.listMatching || params.listNonMatching
+
+	before := newLineRing(params.before)
+	afterLeft := 0
+	matched := 0
+	offset := 0
+
+	// С -c печатается только число совпавших строк, строки контекста не считаются
+	emit := func(l line, selected bool) {
+		if params.toCount {
+			return
+		}
+		out.printLine(l, selected)
+	}
+
+	for num := 1; ; num++ {
+		// Если новых данных пока нет (например, при чтении из tail -f), отдаём уже найденное
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return searchStats{}, err
+			}
+		}
+
+		text, size, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return searchStats{}, err
+		}
+		cur := line{num: num, offset: offset, text: text}
+		offset += size
+
+		// После -m совпадений дочитываем только завершающий контекст
+		if params.maxCount > 0 && matched >= params.maxCount {
+			if afterLeft == 0 || params.toCount {
+				break
+			}
+			emit(cur, false)
+			afterLeft--
+			continue
+		}
+
+		isMatch := checker(cur.text)
+		if isMatch {
+			matched++
+		}
+		if listOnly {
+			if isMatch {
+				break
+			}
+			continue
+		}
+
+		switch {
+		case isMatch:
+			for _, l := range before.drain() {
+				emit(l, false)
+			}
+			emit(cur, true)
+			afterLeft = params.after
+		case afterLeft > 0:
+			emit(cur, false)
+			afterLeft--
+		default:
+			before.push(cur)
+		}
+	}
+
+	switch {
+	case params.listMatching:
+		if matched > 0 {
+			out.printFilename()
+		}
+	case params.listNonMatching:
+		if matched == 0 {
+			out.printFilename()
+		}
+	case params.toCount:
+		out.printCount(matched)
+	}
+
+	stats := searchStats{Searches: 1, BytesSearched: offset, MatchedLines: matched, Matches: out.matches}
+	if matched > 0 {
+		stats.SearchesWithMatch = 1
+	}
+	if params.json {
+		out.printJSONEnd(stats)
+	}
+	return stats, nil
+}
+
+// readLine читает строку без завершающего \n (и \r перед ним), как bufio.ScanLines.
+// Вторым значением возвращается число прочитанных байт вместе с переводом строки
+func readLine(reader *bufio.Reader) (string, int, error) {
+	text, err := reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && text != "") {
+		return "", 0, err
+	}
+	size := len(text)
+	text = strings.TrimSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\r")
+	return text, size, nil
+}
+
+func newChecker(m matcher, invert bool) func(string) bool {
+	if invert {
+		return func(checked string) bool { return !m.match(checked) }
+	}
+	return m.match
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// Сигнатуры сжатых форматов в начале потока
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicBzip2 = []byte("BZh")
+)
+
+// После "BZh" и цифры размера блока в bzip2 идёт сигнатура первого блока или, у пустого потока,
+// сигнатура конца потока. Без них текст, начинающийся с "BZh", принимался бы за bzip2
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+const bzip2HeaderSize = 10
+
+func isBzip2(head []byte) bool {
+	if len(head) < bzip2HeaderSize || !bytes.HasPrefix(head, magicBzip2) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	block := head[4:bzip2HeaderSize]
+	return bytes.Equal(block, bzip2BlockMagic) || bytes.Equal(block, bzip2EndMagic)
+}
+
+// closerFunc позволяет вернуть функцию освобождения ресурсов как io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// wrapInput распаковывает поток (с -z, формат определяется по сигнатуре) и перекодирует его
+// в UTF-8 (с --encoding). Закрывать нужно только возвращённый closer, исходный поток - вызывающему
+func wrapInput(in io.Reader, params parametres) (io.Reader, io.Closer, error) {
+	var reader io.Reader = in
+	var closer io.Closer = closerFunc(func() error { return nil })
+
+	if params.decompress {
+		buffered := bufio.NewReader(in)
+		head, _ := buffered.Peek(bzip2HeaderSize)
+		reader = buffered
+
+		switch {
+		case bytes.HasPrefix(head, magicGzip):
+			gz, err := gzip.NewReader(buffered)
+			if err != nil {
+				return nil, nil, fmt.Errorf("gzip: %w", err)
+			}
+			reader, closer = gz, gz
+		case bytes.HasPrefix(head, magicZstd):
+			zr, err := zstd.NewReader(buffered)
+			if err != nil {
+				return nil, nil, fmt.Errorf("zstd: %w", err)
+			}
+			reader, closer = zr, closerFunc(func() error { zr.Close(); return nil })
+		case isBzip2(head):
+			reader = bzip2.NewReader(buffered)
+		}
+	}
+
+	if params.encoding != "" {
+		enc, err := htmlindex.Get(params.encoding)
+		if err != nil {
+			closer.Close()
+			return nil, nil, fmt.Errorf("encoding %q: %w", params.encoding, err)
+		}
+		reader = transform.NewReader(reader, enc.NewDecoder())
+	}
+
+	return reader, closer, nil
+}
+
+// checkEncoding проверяет имя кодировки до начала поиска
+func checkEncoding(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := htmlindex.Get(name); err != nil {
+		return fmt.Errorf("encoding %q: %w", name, err)
+	}
+	return nil
+}
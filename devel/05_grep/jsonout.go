@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Формат --json повторяет JSON Lines вывод ripgrep: по объекту на строку,
+// типы begin, match, context, end для каждого файла и итоговый summary
+
+type jsonMessage struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// jsonData - строка в виде текста, а если она не UTF-8 - в виде base64 (как в ripgrep)
+type jsonData struct {
+	Text  *string `json:"text,omitempty"`
+	Bytes *string `json:"bytes,omitempty"`
+}
+
+func newJSONData(s string) jsonData {
+	if utf8.ValidString(s) {
+		return jsonData{Text: &s}
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(s))
+	return jsonData{Bytes: &encoded}
+}
+
+type jsonBegin struct {
+	Path jsonData `json:"path"`
+}
+
+type jsonLine struct {
+	Path           jsonData       `json:"path"`
+	Lines          jsonData       `json:"lines"`
+	LineNumber     int            `json:"line_number"`
+	AbsoluteOffset int            `json:"absolute_offset"`
+	Submatches     []jsonSubmatch `json:"submatches"`
+}
+
+type jsonSubmatch struct {
+	Match    jsonData      `json:"match"`
+	Start    int           `json:"start"`
+	End      int           `json:"end"`
+	Captures []jsonCapture `json:"captures,omitempty"`
+}
+
+// jsonCapture - группа захвата. Если группа не участвовала в совпадении, Match == nil
+type jsonCapture struct {
+	Index int       `json:"index"`
+	Name  string    `json:"name,omitempty"`
+	Match *jsonData `json:"match"`
+	Start int       `json:"start"`
+	End   int       `json:"end"`
+}
+
+type jsonEnd struct {
+	Path  jsonData    `json:"path"`
+	Stats searchStats `json:"stats"`
+}
+
+type jsonSummary struct {
+	ElapsedTotal jsonDuration `json:"elapsed_total"`
+	Stats        searchStats  `json:"stats"`
+}
+
+type jsonDuration struct {
+	Secs  int64  `json:"secs"`
+	Nanos int    `json:"nanos"`
+	Human string `json:"human"`
+}
+
+// searchStats - статистика поиска по одному файлу или по всем сразу
+type searchStats struct {
+	Searches          int `json:"searches"`
+	SearchesWithMatch int `json:"searches_with_match"`
+	BytesSearched     int `json:"bytes_searched"`
+	MatchedLines      int `json:"matched_lines"`
+	Matches           int `json:"matches"`
+}
+
+func (s *searchStats) add(other searchStats) {
+	s.Searches += other.Searches
+	s.SearchesWithMatch += other.SearchesWithMatch
+	s.BytesSearched += other.BytesSearched
+	s.MatchedLines += other.MatchedLines
+	s.Matches += other.Matches
+}
+
+func writeJSON(w io.Writer, typ string, data any) error {
+	encoded, err := json.Marshal(jsonMessage{Type: typ, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(encoded, '\n'))
+	return err
+}
+
+// jsonSubmatches переводит позиции совпадений в объекты submatches вместе с группами захвата
+func jsonSubmatches(text string, locs [][]int, names []string) []jsonSubmatch {
+	res := make([]jsonSubmatch, 0, len(locs))
+	for _, loc := range locs {
+		if loc[0] == loc[1] {
+			continue
+		}
+		sub := jsonSubmatch{Match: newJSONData(text[loc[0]:loc[1]]), Start: loc[0], End: loc[1]}
+		for i := 1; 2*i+1 < len(loc); i++ {
+			capture := jsonCapture{Index: i, Start: loc[2*i], End: loc[2*i+1]}
+			if i < len(names) {
+				capture.Name = names[i]
+			}
+			if capture.Start >= 0 {
+				data := newJSONData(text[capture.Start:capture.End])
+				capture.Match = &data
+			}
+			sub.Captures = append(sub.Captures, capture)
+		}
+		res = append(res, sub)
+	}
+	return res
+}
+
+func writeJSONSummary(w io.Writer, stats searchStats, elapsed time.Duration) error {
+	return writeJSON(w, "summary", jsonSummary{
+		ElapsedTotal: jsonDuration{
+			Secs:  int64(elapsed / time.Second),
+			Nanos: int(elapsed % time.Second),
+			Human: elapsed.String(),
+		},
+		Stats: stats,
+	})
+}
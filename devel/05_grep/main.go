@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"regexp"
-	"sort"
+	"runtime"
 	"strings"
 )
 
@@ -20,24 +17,78 @@ type parametres struct {
 	invert     bool
 	fixed      bool
 	withLine   bool
+	wordRegexp bool
+	lineRegexp bool
+
+	byteOffset   bool
+	onlyMatching bool
+	color        bool
+	json         bool
+
+	decompress bool
+	encoding   string
+	// число горутин для поиска внутри одного файла
+	jobs int
+	// 0 - без ограничения
+	maxCount int
+
+	recursive       bool
+	withFilename    bool
+	listMatching    bool
+	listNonMatching bool
+	include         []string
+	exclude         []string
+
+	patterns  []string
+	filenames []string
+}
+
+// patternList - повторяемый флаг -e
+type patternList []string
 
-	pattern  string
-	filename string
+func (p *patternList) String() string {
+	return strings.Join(*p, "\n")
 }
 
-var params parametres
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
 
 func main() {
-	params = parseArgsIntoParams()
-	data, err := readData(params.filename)
+	params, err := parseArgsIntoParams()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	doGrep(data, params, os.Stdout)
+	if len(params.filenames) == 0 || (len(params.filenames) == 1 && params.filenames[0] == "-") {
+		input, closer, err := wrapInput(os.Stdin, params)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer closer.Close()
+		if err := doGrep(input, params, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	failed := false
+	onError := func(err error) {
+		failed = true
+		fmt.Fprintln(os.Stderr, "grep:", err)
+	}
+
+	files := collectFiles(params, onError)
+	if err := searchFiles(files, params, os.Stdout, onError); err != nil {
+		log.Fatalln(err)
+	}
+	if failed {
+		os.Exit(2)
+	}
 }
 
-func parseArgsIntoParams() parametres {
+func parseArgsIntoParams() (parametres, error) {
 	linesAfter := flag.Int("A", 0, "Print N lines after")
 	linesBefore := flag.Int("B", 0, "Print N lines before")
 	linesNear := flag.Int("C", 0, "Print N lines before and after")
@@ -46,6 +97,29 @@ func parseArgsIntoParams() parametres {
 	invert := flag.Bool("v", false, "Invert results")
 	fixed := flag.Bool("F", false, "Fixed pattern")
 	withLine := flag.Bool("n", false, "Print lines with number")
+	wordRegexp := flag.Bool("w", false, "Match only whole words")
+	lineRegexp := flag.Bool("x", false, "Match only whole lines")
+	patternFile := flag.String("f", "", "Read patterns from file, one per line")
+	byteOffset := flag.Bool("b", false, "Print byte offset of each line (of each match with -o)")
+	onlyMatching := flag.Bool("o", false, "Print only matched parts of lines")
+	color := flag.String("color", "never", "Highlight matches: auto, always or never")
+	maxCount := flag.Int("m", -1, "Stop after NUM matching lines")
+	jsonOut := flag.Bool("json", false, "Print results as JSON Lines in ripgrep format")
+	decompress := flag.Bool("z", false, "Decompress gzip, zstd and bzip2 input on the fly")
+	encoding := flag.String("encoding", "", "Input encoding, e.g. windows-1251 (default UTF-8)")
+	jobs := flag.Int("j", 1, "Split each file into chunks searched by N goroutines (0 - number of CPUs)")
+	recursive := flag.Bool("r", false, "Search directories recursively")
+	withFilename := flag.Bool("H", false, "Print file name for each match")
+	noFilename := flag.Bool("h", false, "Never print file names")
+	listMatching := flag.Bool("l", false, "Print only names of files with matches")
+	listNonMatching := flag.Bool("L", false, "Print only names of files without matches")
+
+	var patterns patternList
+	flag.Var(&patterns, "e", "Use PATTERN for matching (repeatable)")
+
+	var include, exclude globList
+	flag.Var(&include, "include", "Search only files whose base name matches GLOB (repeatable)")
+	flag.Var(&exclude, "exclude", "Skip files whose base name matches GLOB (repeatable)")
 
 	flag.Parse()
 
@@ -56,150 +130,97 @@ func parseArgsIntoParams() parametres {
 		ignoreCase: *ignore,
 		invert:     *invert,
 		fixed:      *fixed,
+		wordRegexp: *wordRegexp,
+		lineRegexp: *lineRegexp,
 
-		withLine: *withLine,
-	}
+		withLine:     *withLine,
+		byteOffset:   *byteOffset,
+		onlyMatching: *onlyMatching,
+		json:         *jsonOut,
+		decompress:   *decompress,
+		encoding:     *encoding,
+		jobs:         *jobs,
 
-	if *linesNear != 0 {
-		params.after = *linesNear
-		params.before = *linesNear
+		recursive:       *recursive,
+		listMatching:    *listMatching,
+		listNonMatching: *listNonMatching,
+		include:         include,
+		exclude:         exclude,
 	}
 
-	if len(flag.Args()) != 2 {
-		return params
+	switch *color {
+	case "always":
+		params.color = true
+	case "auto":
+		params.color = isTerminal(os.Stdout)
+	case "never":
+	default:
+		return parametres{}, fmt.Errorf("bad -color value %q: want auto, always or never", *color)
 	}
-	params.pattern = flag.Args()[0]
-	params.filename = flag.Args()[1]
-
-	return params
-}
-
-func doGrep(data []string, params parametres, out io.Writer) {
-	// Находим нужные индексы строк для вывода
-	indices := findIndices(data, params)
 
-	// добавляем строки сверху/снизу по ключам -A -B -C
-	indices = addAreaIndices(indices, params, len(data))
-
-	// Выводим результат
-	printResult(data, indices, out, params.toCount)
-}
-
-func printResult(data []string, indices []int, out io.Writer, isCountNeed bool) {
-	if isCountNeed {
-		fmt.Fprintf(out, "%d\n", len(indices))
-		return
+	if params.jobs <= 0 {
+		params.jobs = runtime.NumCPU()
 	}
 
-	for i, index := range indices {
-		if i != len(indices)-1 {
-			fmt.Fprintf(out, "%s\n", data[index])
-		} else {
-			fmt.Fprintf(out, "%s", data[index])
-		}
+	if err := checkEncoding(params.encoding); err != nil {
+		return parametres{}, err
 	}
-}
-
-// проверяем за счёт текста
-func checkByRaw(checked string) bool {
-	var ans bool
-	if params.ignoreCase {
-		ans = strings.Contains(strings.ToLower(checked), strings.ToLower(params.pattern))
-	} else {
-		ans = strings.Contains(checked, params.pattern)
+	if params.json && (params.toCount || params.listMatching || params.listNonMatching || params.onlyMatching) {
+		return parametres{}, fmt.Errorf("-json can't be combined with -c, -l, -L or -o")
 	}
-	return ans
-}
 
-// проверяем на счёт регекса
-func checkByRegex(checked string) bool {
-	var ans bool
-	pattern := params.pattern
-	if params.ignoreCase {
-		pattern = "(?i)" + pattern
+	switch {
+	case *maxCount == 0:
+		// как в GNU grep: -m 0 - не читать вход вовсе
+		os.Exit(1)
+	case *maxCount > 0:
+		params.maxCount = *maxCount
 	}
-	ans, _ = regexp.MatchString(pattern, checked)
-	return ans
-}
-
-func findIndices(data []string, params parametres) []int {
-	var indices []int
 
-	inverter := func(v bool) bool {
-		if params.invert {
-			return !v
-		}
-		return v
+	if *linesNear != 0 {
+		params.after = *linesNear
+		params.before = *linesNear
 	}
-
-	var checker func(string) bool
-	if params.fixed {
-		checker = checkByRaw
-	} else {
-		checker = checkByRegex
+	// Как в GNU grep: с -c контекст не печатается и не считается
+	if params.toCount {
+		params.after, params.before = 0, 0
 	}
 
-	for i := 0; i < len(data); i++ {
-		if inverter(checker(data[i])) {
-			indices = append(indices, i)
+	// Шаблоны из -e и -f объединяются, иначе шаблон - первый позиционный аргумент
+	args := flag.Args()
+	params.patterns = patterns
+	if *patternFile != "" {
+		fromFile, err := readPatternFile(*patternFile)
+		if err != nil {
+			return parametres{}, err
 		}
+		params.patterns = append(params.patterns, fromFile...)
 	}
-
-	return indices
-}
-
-func addAreaIndices(inds []int, params parametres, maxIndex int) []int {
-	if params.after == 0 && params.before == 0 {
-		return inds
-	}
-
-	unique := make(map[int]struct{})
-	for _, index := range inds {
-		unique[index] = struct{}{}
-
-		for i := 1; i <= params.before; i++ {
-			newIndex := index - i
-			if newIndex >= 0 {
-				unique[newIndex] = struct{}{}
-			}
-		}
-
-		for i := 1; i <= params.after; i++ {
-			newIndex := index + i
-			if newIndex < maxIndex {
-				unique[newIndex] = struct{}{}
-			}
+	if len(patterns) == 0 && *patternFile == "" {
+		if len(args) == 0 {
+			return parametres{}, fmt.Errorf("pattern is required")
 		}
+		params.patterns = []string{args[0]}
+		args = args[1:]
+	}
+	params.filenames = args
+	if params.recursive && len(params.filenames) == 0 {
+		params.filenames = []string{"."}
 	}
 
-	newIndices := make([]int, 0, len(unique))
-	for key := range unique {
-		newIndices = append(newIndices, key)
+	// Как в GNU grep: имена файлов печатаются, если файлов несколько или идёт рекурсивный обход
+	params.withFilename = len(params.filenames) > 1 || params.recursive
+	if *withFilename {
+		params.withFilename = true
+	}
+	if *noFilename {
+		params.withFilename = false
 	}
-	sort.Ints(newIndices)
 
-	return newIndices
+	return params, nil
 }
 
-func readData(filename string) ([]string, error) {
-	var input io.Reader
-	if filename == "" {
-		input = os.Stdin
-	} else {
-		file, err := os.Open(filename)
-		if err != nil {
-			return []string{}, err
-		}
-		defer file.Close()
-	}
-
-	scanner := bufio.NewScanner(input)
-	lines := []string{}
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		return []string{}, err
-	}
-	return lines, nil
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -3,9 +3,20 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func readFileOrPanic(filename string) []string {
@@ -26,6 +37,16 @@ func readFileOrPanic(filename string) []string {
 	return lines
 }
 
+// joinLines собирает ожидаемый вывод: каждая строка завершается переводом строки
+func joinLines(lines []string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 func Test_doGrep(t *testing.T) {
 	type args struct {
 		data   []string
@@ -40,23 +61,23 @@ func Test_doGrep(t *testing.T) {
 			name: "default Find USD",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_1.txt"),
-				params: parametres{pattern: "USD"},
+				params: parametres{patterns: []string{"USD"}},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/default_1_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/default_1_ans.txt")),
 		},
 		{
 			name: "default Find last eur (eur$)",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_2.txt"),
-				params: parametres{pattern: "eur$"},
+				params: parametres{patterns: []string{"eur$"}},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/default_2_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/default_2_ans.txt")),
 		},
 		{
 			name: "default_1 Count",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_1.txt"),
-				params: parametres{pattern: "u", toCount: true},
+				params: parametres{patterns: []string{"u"}, toCount: true},
 			},
 			wantOut: "3\n",
 		},
@@ -64,7 +85,7 @@ func Test_doGrep(t *testing.T) {
 			name: "default_1 Count_ignore_case",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_2.txt"),
-				params: parametres{pattern: "usd", toCount: true, ignoreCase: true},
+				params: parametres{patterns: []string{"usd"}, toCount: true, ignoreCase: true},
 			},
 			wantOut: "4\n",
 		},
@@ -72,7 +93,15 @@ func Test_doGrep(t *testing.T) {
 			name: "default_1 Count_ignore_case",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_2.txt"),
-				params: parametres{pattern: "usd", toCount: true, ignoreCase: true, invert: true},
+				params: parametres{patterns: []string{"usd"}, toCount: true, ignoreCase: true, invert: true},
+			},
+			wantOut: "2\n",
+		},
+		{
+			name: "count ignores context",
+			args: args{
+				data:   []string{"a", "b", "a", "c"},
+				params: parametres{patterns: []string{"a"}, toCount: true, after: 1},
 			},
 			wantOut: "2\n",
 		},
@@ -80,7 +109,7 @@ func Test_doGrep(t *testing.T) {
 			name: "fixed no pattern",
 			args: args{
 				data:   readFileOrPanic("testFiles/default_2.txt"),
-				params: parametres{pattern: "byn$", fixed: true},
+				params: parametres{patterns: []string{"byn$"}, fixed: true},
 			},
 			wantOut: "",
 		},
@@ -89,42 +118,754 @@ func Test_doGrep(t *testing.T) {
 			name: "out -c ",
 			args: args{
 				data:   readFileOrPanic("testFiles/out.txt"),
-				params: parametres{pattern: "bitoc$", after: 2, before: 2},
+				params: parametres{patterns: []string{"bitoc$"}, after: 2, before: 2},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/out_c_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/out_c_ans.txt")),
 		},
 		{
 			name: "out -C ",
 			args: args{
 				data:   readFileOrPanic("testFiles/out.txt"),
-				params: parametres{pattern: "bitoc$", after: 2, before: 2},
+				params: parametres{patterns: []string{"bitoc$"}, after: 2, before: 2},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/out_c_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/out_c_ans.txt")),
 		},
 		{
 			name: "out -B first line",
 			args: args{
 				data:   readFileOrPanic("testFiles/out.txt"),
-				params: parametres{pattern: "USD", before: 2},
+				params: parametres{patterns: []string{"USD"}, before: 2},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/out_b_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/out_b_ans.txt")),
 		},
 		{
 			name: "out -A last line",
 			args: args{
 				data:   readFileOrPanic("testFiles/out.txt"),
-				params: parametres{pattern: "bitoc byn", after: 2},
+				params: parametres{patterns: []string{"bitoc byn"}, after: 2},
 			},
-			wantOut: strings.Join(readFileOrPanic("testFiles/out_a_ans.txt"), "\n"),
+			wantOut: joinLines(readFileOrPanic("testFiles/out_a_ans.txt")),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			out := &bytes.Buffer{}
-			doGrep(tt.args.data, tt.args.params, out)
+			if err := doGrep(strings.NewReader(strings.Join(tt.args.data, "\n")), tt.args.params, out); err != nil {
+				t.Fatalf("doGrep() error = %v", err)
+			}
 			if gotOut := out.String(); gotOut != tt.wantOut {
 				t.Errorf("doGrep() = %v, want %v", gotOut, tt.wantOut)
 			}
 		})
 	}
 }
+
+// referenceGrep - прежняя реализация, загружающая весь вход в память.
+// Потоковый doGrep должен выдавать в точности такой же результат
+func referenceGrep(data []string, params parametres, out io.Writer) {
+	m, err := newMatcher(params)
+	if err != nil {
+		panic(err)
+	}
+	checker := newChecker(m, params.invert)
+
+	var indices []int
+	for i := 0; i < len(data); i++ {
+		if checker(data[i]) {
+			indices = append(indices, i)
+		}
+	}
+
+	// -c считает только совпавшие строки, без контекста
+	if params.toCount {
+		fmt.Fprintf(out, "%d\n", len(indices))
+		return
+	}
+
+	if params.after != 0 || params.before != 0 {
+		unique := make(map[int]struct{})
+		for _, index := range indices {
+			for i := index - params.before; i <= index+params.after; i++ {
+				if i >= 0 && i < len(data) {
+					unique[i] = struct{}{}
+				}
+			}
+		}
+		indices = indices[:0]
+		for key := range unique {
+			indices = append(indices, key)
+		}
+		sort.Ints(indices)
+	}
+
+	for i, index := range indices {
+		// группы контекста разделяются "--"
+		if (params.after != 0 || params.before != 0) && i > 0 && index > indices[i-1]+1 {
+			fmt.Fprintln(out, "--")
+		}
+		fmt.Fprintf(out, "%s\n", data[index])
+	}
+}
+
+func Test_doGrep_sameAsReference(t *testing.T) {
+	data := readFileOrPanic("testFiles/out.txt")
+	for _, pattern := range []string{"bitoc", "^non$", "usd", "zzz", "."} {
+		for _, before := range []int{0, 1, 2, 5} {
+			for _, after := range []int{0, 1, 3} {
+				for _, flags := range []parametres{{}, {invert: true}, {ignoreCase: true}, {toCount: true}} {
+					params := flags
+					params.patterns, params.before, params.after = []string{pattern}, before, after
+
+					want := &bytes.Buffer{}
+					referenceGrep(data, params, want)
+					got := &bytes.Buffer{}
+					if err := doGrep(strings.NewReader(strings.Join(data, "\n")+"\n"), params, got); err != nil {
+						t.Fatalf("doGrep() error = %v", err)
+					}
+					if got.String() != want.String() {
+						t.Errorf("params %+v: doGrep() = %q, want %q", params, got.String(), want.String())
+					}
+				}
+			}
+		}
+	}
+}
+
+// Совпадение должно появляться на выходе до того, как вход закрыт (как в tail -f | grep)
+func Test_doGrep_streaming(t *testing.T) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- doGrep(inReader, parametres{patterns: []string{"match"}}, outWriter)
+		outWriter.Close()
+	}()
+
+	if _, err := io.WriteString(inWriter, "skip\nmatch 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("match 1"))
+	if _, err := io.ReadFull(outReader, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "match 1" {
+		t.Errorf("got %q before input closed, want %q", buf, "match 1")
+	}
+
+	inWriter.Close()
+	io.Copy(io.Discard, outReader)
+	if err := <-done; err != nil {
+		t.Errorf("doGrep() error = %v", err)
+	}
+}
+
+// writeTree создаёт файлы во временном каталоге, ключ - путь через "/"
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func Test_searchFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.txt":               "usd 1\neur\n",
+		"b.go":                "usd 2\n",
+		"bin.dat":             "usd\x00binary\n",
+		".gitignore":          "*.log\nbuild/\n!keep.log\n/top.txt\n",
+		"top.txt":             "usd top\n",
+		"debug.log":           "usd log\n",
+		"keep.log":            "usd keep\n",
+		"build/out.txt":       "usd build\n",
+		"sub/top.txt":         "usd sub top\n",
+		"sub/c.txt":           "eur\n",
+		"sub/.gitignore":      "c.txt\n",
+		"sub/d.txt":           "usd d\n",
+		"deep/x/y/z.txt":      "usd z\n",
+		".git/objects/usd.gz": "usd git\n",
+	})
+	rel := func(name string) string { return filepath.Join(root, filepath.FromSlash(name)) }
+
+	tests := []struct {
+		name    string
+		params  parametres
+		wantOut string
+	}{
+		{
+			name:   "recursive with gitignore",
+			params: parametres{patterns: []string{"usd"}, recursive: true, withFilename: true},
+			wantOut: joinLines([]string{
+				rel("a.txt") + ":usd 1",
+				rel("b.go") + ":usd 2",
+				rel("deep/x/y/z.txt") + ":usd z",
+				rel("keep.log") + ":usd keep",
+				rel("sub/d.txt") + ":usd d",
+				rel("sub/top.txt") + ":usd sub top",
+			}),
+		},
+		{
+			name:    "include",
+			params:  parametres{patterns: []string{"usd"}, recursive: true, withFilename: true, include: []string{"*.go"}},
+			wantOut: joinLines([]string{rel("b.go") + ":usd 2"}),
+		},
+		{
+			name:   "exclude",
+			params: parametres{patterns: []string{"usd"}, recursive: true, exclude: []string{"*.txt"}},
+			wantOut: joinLines([]string{
+				"usd 2",
+				"usd keep",
+			}),
+		},
+		{
+			name:   "list matching",
+			params: parametres{patterns: []string{"eur"}, recursive: true, listMatching: true},
+			wantOut: joinLines([]string{
+				rel("a.txt"),
+			}),
+		},
+		{
+			name:    "list non matching",
+			params:  parametres{patterns: []string{"usd"}, recursive: true, listNonMatching: true, include: []string{"*.txt"}},
+			wantOut: "",
+		},
+		{
+			name:   "context groups of different files",
+			params: parametres{patterns: []string{"usd"}, recursive: true, withFilename: true, after: 1, include: []string{"a.txt", "b.go", "c.txt", "d.txt"}},
+			wantOut: joinLines([]string{
+				rel("a.txt") + ":usd 1",
+				rel("a.txt") + "-eur",
+				"--",
+				rel("b.go") + ":usd 2",
+				"--",
+				rel("sub/d.txt") + ":usd d",
+			}),
+		},
+		{
+			name:   "count per file",
+			params: parametres{patterns: []string{"usd"}, recursive: true, withFilename: true, toCount: true, include: []string{"a.txt", "d.txt"}},
+			wantOut: joinLines([]string{
+				rel("a.txt") + ":1",
+				rel("sub/d.txt") + ":1",
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			params.filenames = []string{root}
+
+			var errs []error
+			files := collectFiles(params, func(err error) { errs = append(errs, err) })
+			out := &bytes.Buffer{}
+			if err := searchFiles(files, params, out, func(err error) { errs = append(errs, err) }); err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) != 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("searchFiles() = %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func Test_collectFiles_errors(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.txt": "usd\n"})
+
+	var errs []error
+	files := collectFiles(parametres{filenames: []string{root, filepath.Join(root, "missing"), filepath.Join(root, "a.txt")}},
+		func(err error) { errs = append(errs, err) })
+
+	if len(files) != 1 || files[0] != filepath.Join(root, "a.txt") {
+		t.Errorf("collectFiles() = %v, want only a.txt", files)
+	}
+	// каталог без -r и несуществующий файл
+	if len(errs) != 2 {
+		t.Errorf("collectFiles() errors = %v, want 2", errs)
+	}
+}
+
+func Test_newMatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		params parametres
+		text   string
+		want   [][]int
+	}{
+		{"regex several", parametres{patterns: []string{"usd", "e.r"}}, "eur usd", [][]int{{0, 3}, {4, 7}}},
+		{"regex ignore case", parametres{patterns: []string{"USD"}, ignoreCase: true}, "usd", [][]int{{0, 3}}},
+		{"regex word", parametres{patterns: []string{"usd"}, wordRegexp: true}, "usdx usd _usd", [][]int{{5, 8}}},
+		{"regex word alternative", parametres{patterns: []string{"ab|abc"}, wordRegexp: true}, "abc ab", [][]int{{0, 3}, {4, 6}}},
+		{"regex word alternative unicode", parametres{patterns: []string{"руб|рубль"}, wordRegexp: true}, "рубль, руб", [][]int{{0, 10}, {12, 18}}},
+		{"regex word unicode", parametres{patterns: []string{"руб"}, wordRegexp: true}, "рубль руб", [][]int{{11, 17}}},
+		{"regex line", parametres{patterns: []string{"usd", "eur"}, lineRegexp: true}, "usd eur", nil},
+		{"regex line match", parametres{patterns: []string{"usd", "eur"}, lineRegexp: true}, "eur", [][]int{{0, 3}}},
+		{"fixed special chars", parametres{patterns: []string{"a.b"}, fixed: true}, "axb a.b", [][]int{{4, 7}}},
+		{"fixed several longest", parametres{patterns: []string{"he", "hers", "she"}, fixed: true}, "ushers", [][]int{{1, 4}}},
+		{"fixed several ignore case", parametres{patterns: []string{"usd", "EUR"}, fixed: true, ignoreCase: true}, "Eur USD", [][]int{{0, 3}, {4, 7}}},
+		{"fixed word", parametres{patterns: []string{"usd", "usdt"}, fixed: true, wordRegexp: true}, "usdt usdx", [][]int{{0, 4}}},
+		{"fixed line", parametres{patterns: []string{"USD"}, fixed: true, lineRegexp: true, ignoreCase: true}, "usd", [][]int{{0, 3}}},
+		{"fixed unicode ignore case", parametres{patterns: []string{"РУБ", "usd"}, fixed: true, ignoreCase: true}, "руб", [][]int{{0, 6}}},
+		{"fixed empty", parametres{patterns: []string{""}, fixed: true}, "abc", [][]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}}},
+		{"no patterns", parametres{}, "abc", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.findAll(tt.text)
+			if len(got) == 0 && len(tt.want) == 0 {
+				if m.match(tt.text) {
+					t.Errorf("match() = true, want false")
+				}
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("findAll() = %v, want %v", got, tt.want)
+			}
+			if !m.match(tt.text) {
+				t.Errorf("match() = false, want true")
+			}
+		})
+	}
+}
+
+func Test_newMatcher_badPattern(t *testing.T) {
+	_, err := newMatcher(parametres{patterns: []string{"usd", "(eur"}})
+	if err == nil || !strings.Contains(err.Error(), "(eur") {
+		t.Errorf("newMatcher() error = %v, want error about (eur", err)
+	}
+	if err := doGrep(strings.NewReader("usd\n"), parametres{patterns: []string{"["}}, io.Discard); err == nil {
+		t.Errorf("doGrep() with bad pattern: want error")
+	}
+}
+
+// Aho-Corasick должен находить то же, что и регулярное выражение из экранированных шаблонов
+func Test_ahoCorasick_sameAsRegex(t *testing.T) {
+	patterns := []string{"a", "ab", "bab", "bc", "bca", "c", "caa", "Ab"}
+	texts := []string{"", "abccab", "bababcaab", "cccc", "xyz", "ABCAAB", "aBcAbC"}
+	for _, ignoreCase := range []bool{false, true} {
+		for _, word := range []bool{false, true} {
+			params := parametres{patterns: patterns, ignoreCase: ignoreCase, wordRegexp: word}
+			reParams := params
+			reParams.patterns = make([]string, len(patterns))
+			for i, p := range patterns {
+				reParams.patterns[i] = regexp.QuoteMeta(p)
+			}
+			fixed, _ := newMatcher(parametres{patterns: patterns, ignoreCase: ignoreCase, wordRegexp: word, fixed: true})
+			re, err := newMatcher(reParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, text := range texts {
+				if fixed.match(text) != re.match(text) {
+					t.Errorf("%+v %q: match() = %v, regex %v", params, text, fixed.match(text), re.match(text))
+				}
+			}
+		}
+	}
+}
+
+func Test_doGrep_output(t *testing.T) {
+	data := "usd 1\neur\nrub\nbyn\nusd usd\nrub\n"
+	tests := []struct {
+		name    string
+		params  parametres
+		wantOut string
+	}{
+		{
+			name:    "line numbers and markers",
+			params:  parametres{patterns: []string{"usd"}, withLine: true, after: 1},
+			wantOut: "1:usd 1\n2-eur\n--\n5:usd usd\n6-rub\n",
+		},
+		{
+			name:    "filename, number and offset",
+			params:  parametres{patterns: []string{"eur"}, withFilename: true, withLine: true, byteOffset: true, before: 1},
+			wantOut: "(standard input)-1-0-usd 1\n(standard input):2:6:eur\n",
+		},
+		{
+			name:    "only matching with offsets",
+			params:  parametres{patterns: []string{"usd"}, onlyMatching: true, byteOffset: true, after: 2},
+			wantOut: "0:usd\n18:usd\n22:usd\n",
+		},
+		{
+			name:    "only matching inverted prints nothing",
+			params:  parametres{patterns: []string{"usd"}, onlyMatching: true, invert: true},
+			wantOut: "",
+		},
+		{
+			name:    "max count with trailing context",
+			params:  parametres{patterns: []string{"usd|rub"}, maxCount: 2, after: 1},
+			wantOut: "usd 1\neur\nrub\nbyn\n",
+		},
+		{
+			name:    "max count with count",
+			params:  parametres{patterns: []string{"usd|rub"}, maxCount: 3, toCount: true},
+			wantOut: "3\n",
+		},
+		{
+			name:   "color",
+			params: parametres{patterns: []string{"usd"}, color: true, withLine: true, maxCount: 2},
+			wantOut: "\x1b[32m\x1b[K1\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K\x1b[01;31m\x1b[Kusd\x1b[m\x1b[K 1\n" +
+				"\x1b[32m\x1b[K5\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[K\x1b[01;31m\x1b[Kusd\x1b[m\x1b[K \x1b[01;31m\x1b[Kusd\x1b[m\x1b[K\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := doGrep(strings.NewReader(data), tt.params, out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("doGrep() = %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func Test_doGrep_json(t *testing.T) {
+	data := "usd 1\neur\nusd 22 usd 3\n"
+	out := &bytes.Buffer{}
+	params := parametres{patterns: []string{`usd (?P<num>\d+)`}, json: true, before: 1}
+	if err := doGrep(strings.NewReader(data), params, out); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	var messages []map[string]any
+	for _, l := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(l), &msg); err != nil {
+			t.Fatalf("bad json line %q: %v", l, err)
+		}
+		types = append(types, msg["type"].(string))
+		messages = append(messages, msg["data"].(map[string]any))
+	}
+	if want := "begin match context match end summary"; strings.Join(types, " ") != want {
+		t.Fatalf("types = %v, want %v", types, want)
+	}
+
+	second := messages[3]
+	if second["line_number"] != 3.0 || second["absolute_offset"] != 10.0 {
+		t.Errorf("match = %v, want line 3 at offset 10", second)
+	}
+	if lines := second["lines"].(map[string]any)["text"]; lines != "usd 22 usd 3\n" {
+		t.Errorf("lines = %q", lines)
+	}
+	submatches := second["submatches"].([]any)
+	if len(submatches) != 2 {
+		t.Fatalf("submatches = %v, want 2", submatches)
+	}
+	sub := submatches[1].(map[string]any)
+	if sub["start"] != 7.0 || sub["end"] != 12.0 {
+		t.Errorf("submatch = %v, want [7, 12)", sub)
+	}
+	capture := sub["captures"].([]any)[0].(map[string]any)
+	if capture["name"] != "num" || capture["match"].(map[string]any)["text"] != "3" || capture["start"] != 11.0 {
+		t.Errorf("capture = %v, want num=3 at 11", capture)
+	}
+	if submatches := messages[2]["submatches"].([]any); len(submatches) != 0 {
+		t.Errorf("context submatches = %v, want empty", submatches)
+	}
+
+	stats := messages[5]["stats"].(map[string]any)
+	if stats["matched_lines"] != 2.0 || stats["matches"] != 3.0 || stats["bytes_searched"] != float64(len(data)) {
+		t.Errorf("summary stats = %v", stats)
+	}
+}
+
+func Test_searchFiles_compressed(t *testing.T) {
+	data, err := os.ReadFile("testFiles/out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(data)
+	gzw.Close()
+
+	var zst bytes.Buffer
+	zw, _ := zstd.NewWriter(&zst)
+	zw.Write(data)
+	zw.Close()
+
+	bz2, err := os.ReadFile("testFiles/out.txt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{filepath.Join(root, "out.gz"), filepath.Join(root, "out.zst"), filepath.Join(root, "out.bz2")}
+	for i, content := range [][]byte{gz.Bytes(), zst.Bytes(), bz2} {
+		if err := os.WriteFile(files[i], content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// без -z сжатые файлы считаются двоичными или просто не совпадают
+	params := parametres{patterns: []string{"bitoc"}, toCount: true, withFilename: true}
+	out := &bytes.Buffer{}
+	if err := searchFiles(files, params, out, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), ":2") {
+		t.Errorf("without -z: %q", out.String())
+	}
+
+	params.decompress = true
+	out.Reset()
+	if err := searchFiles(files, params, out, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	}
+	want := joinLines([]string{files[0] + ":2", files[1] + ":2", files[2] + ":2"})
+	if out.String() != want {
+		t.Errorf("searchFiles() = %q, want %q", out.String(), want)
+	}
+
+	// Текст, который только начинается как bzip2, с -z читается как есть
+	plain := filepath.Join(root, "plain.txt")
+	if err := os.WriteFile(plain, []byte("BZh9 bitoc\nbitoc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := searchFiles([]string{plain}, params, out, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	}
+	if want := joinLines([]string{plain + ":2"}); out.String() != want {
+		t.Errorf("searchFiles() = %q, want %q", out.String(), want)
+	}
+}
+
+func Test_searchFiles_order(t *testing.T) {
+	contents := map[string]string{}
+	var files, want []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("f%02d.txt", i)
+		var lines []string
+		// файлы разного размера, чтобы они заканчивались не по порядку
+		for j := 0; j < (40-i)*50; j++ {
+			lines = append(lines, fmt.Sprintf("usd %d %d", i, j))
+		}
+		contents[name] = joinLines(lines)
+		want = append(want, lines...)
+	}
+	root := writeTree(t, contents)
+	for i := 0; i < 40; i++ {
+		files = append(files, filepath.Join(root, fmt.Sprintf("f%02d.txt", i)))
+	}
+
+	out := &bytes.Buffer{}
+	if err := searchFiles(files, parametres{patterns: []string{"usd"}}, out, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != joinLines(want) {
+		t.Errorf("searchFiles() printed files out of order")
+	}
+}
+
+func Test_orderedOutput(t *testing.T) {
+	out := &bytes.Buffer{}
+	o := newOrderedOutput(out, 2, 8)
+
+	// Первый в очереди файл пишет сразу в out
+	io.WriteString(o.file(0), "a\n")
+	if out.String() != "a\n" {
+		t.Errorf("head output = %q, want written through", out.String())
+	}
+
+	// Следующий копит вывод до limit, а дальше ждёт своей очереди
+	io.WriteString(o.file(1), "1234")
+	done := make(chan struct{})
+	go func() {
+		io.WriteString(o.file(1), "56789")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Write over the limit did not wait for its turn")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	o.next()
+	<-done
+	if want := "a\n123456789"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func Test_wrapInput_encoding(t *testing.T) {
+	// "курс рубля" в windows-1251
+	data := []byte{0xea, 0xf3, 0xf0, 0xf1, ' ', 0xf0, 0xf3, 0xe1, 0xeb, 0xff, '\n', 'u', 's', 'd', '\n'}
+	input, closer, err := wrapInput(bytes.NewReader(data), parametres{encoding: "windows-1251"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	out := &bytes.Buffer{}
+	if err := doGrep(input, parametres{patterns: []string{"рубл"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "курс рубля\n" {
+		t.Errorf("doGrep() = %q, want %q", out.String(), "курс рубля\n")
+	}
+
+	if _, _, err := wrapInput(bytes.NewReader(data), parametres{encoding: "no-such-encoding"}); err == nil {
+		t.Errorf("wrapInput() with unknown encoding: want error")
+	}
+}
+
+// Строки длиннее лимита bufio.Scanner (64 KiB) должны обрабатываться целиком
+func Test_doGrep_longLine(t *testing.T) {
+	long := strings.Repeat("x", 1<<20) + "usd"
+	out := &bytes.Buffer{}
+	if err := doGrep(strings.NewReader("eur\n"+long+"\nrub\n"), parametres{patterns: []string{"usd$"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != long+"\n" {
+		t.Errorf("doGrep() returned %d bytes, want %d", out.Len(), len(long)+1)
+	}
+}
+
+// Параллельный поиск по кускам должен давать тот же вывод, что и последовательный,
+// в том числе когда контекст и группы переходят через границы кусков
+func Test_grepParallel_sameAsSequential(t *testing.T) {
+	data, err := os.ReadFile("testFiles/out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// без перевода строки в конце и с очень короткими строками
+	data = append(bytes.Repeat(append(data, "\nusd\n\n"...), 3), "last usd"...)
+	name := filepath.Join(t.TempDir(), "big.txt")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flagSets := []parametres{
+		{}, {invert: true}, {toCount: true}, {withLine: true, byteOffset: true},
+		{onlyMatching: true, byteOffset: true}, {json: true},
+	}
+	for _, chunkSize := range []int64{1, 7, 13, 64, 1 << 20} {
+		for _, pattern := range []string{"bitoc", "usd", "^$", "zzz"} {
+			for _, ctx := range [][2]int{{0, 0}, {1, 0}, {0, 2}, {3, 3}, {20, 1}} {
+				for _, flags := range flagSets {
+					params := flags
+					params.patterns, params.before, params.after, params.jobs = []string{pattern}, ctx[0], ctx[1], 4
+					m, err := newMatcher(params)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					want := &bytes.Buffer{}
+					wantWriter := bufio.NewWriter(want)
+					wantStats, err := grepStream(bytes.NewReader(data), name, params, m, wantWriter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantWriter.Flush()
+
+					file, err := os.Open(name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got := &bytes.Buffer{}
+					gotWriter := bufio.NewWriter(got)
+					gotStats, err := grepParallel(file, int64(len(data)), chunkSize, name, params, m, gotWriter)
+					file.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					gotWriter.Flush()
+
+					if got.String() != want.String() || gotStats != wantStats {
+						t.Fatalf("chunk %d, params %+v:\ngot  %q %+v\nwant %q %+v",
+							chunkSize, params, got.String(), gotStats, want.String(), wantStats)
+					}
+				}
+			}
+		}
+	}
+}
+
+// С -j файл, который нельзя читать через ReadAt, ищется последовательно
+func Test_grepFile_pipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	name := fmt.Sprintf("/dev/fd/%d", r.Fd())
+	if _, err := os.Stat(name); err != nil {
+		t.Skip("no /dev/fd:", err)
+	}
+	go func() {
+		w.WriteString("usd\nbitoc\nusd byn\n")
+		w.Close()
+	}()
+
+	params := parametres{patterns: []string{"usd"}, jobs: 4}
+	m, err := newMatcher(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &bytes.Buffer{}
+	stats, err := grepFile(name, params, m, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "usd\nusd byn\n"; got.String() != want || stats.MatchedLines != 2 {
+		t.Errorf("got %q %+v, want %q", got.String(), stats, want)
+	}
+}
+
+// benchFile создаёт файл примерно на size байт из строк testFiles/out.txt
+func benchFile(b *testing.B, size int) string {
+	b.Helper()
+	data, err := os.ReadFile("testFiles/out.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	name := filepath.Join(b.TempDir(), "bench.txt")
+	if err := os.WriteFile(name, bytes.Repeat(data, size/len(data)+1), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return name
+}
+
+func benchmarkGrepFile(b *testing.B, jobs int) {
+	name := benchFile(b, 64<<20)
+	params := parametres{patterns: []string{`bitoc\s+byn`}, before: 1, after: 1, toCount: true, jobs: jobs}
+	m, err := newMatcher(params)
+	if err != nil {
+		b.Fatal(err)
+	}
+	info, _ := os.Stat(name)
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := grepFile(name, params, m, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_grepFile_sequential(b *testing.B) {
+	benchmarkGrepFile(b, 1)
+}
+
+func Benchmark_grepFile_parallel(b *testing.B) {
+	benchmarkGrepFile(b, max(2, runtime.NumCPU()))
+}
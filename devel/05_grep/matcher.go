@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// С какого числа фиксированных строк выгоднее один проход Aho-Corasick, чем поиск каждой по очереди
+const ahoCorasickMinPatterns = 2
+
+// matcher ищет шаблоны в строке. Шаблоны компилируются один раз до начала поиска
+type matcher interface {
+	// match - есть ли в строке совпадение
+	match(text string) bool
+	// findAll возвращает границы [start, end) непересекающихся совпадений слева направо
+	findAll(text string) [][]int
+	// findAllSubmatch - как findAll, но после границ совпадения идут границы групп захвата
+	// (-1, если группа не участвовала), как в regexp.FindAllStringSubmatchIndex
+	findAllSubmatch(text string) [][]int
+	// groupNames - имена групп захвата, нулевой элемент соответствует всему совпадению
+	groupNames() []string
+}
+
+// newMatcher собирает matcher из всех шаблонов (-e, -f или позиционного аргумента)
+func newMatcher(params parametres) (matcher, error) {
+	if params.fixed && canUseFixed(params) {
+		return newFixedMatcher(params), nil
+	}
+
+	exprs := make([]string, 0, len(params.patterns))
+	for _, p := range params.patterns {
+		if params.fixed {
+			p = regexp.QuoteMeta(p)
+		} else if _, err := regexp.Compile(p); err != nil {
+			// Проверяем шаблоны по отдельности, чтобы в ошибке был виден конкретный шаблон
+			return nil, fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+		exprs = append(exprs, "(?:"+p+")")
+	}
+
+	// Без шаблонов (например, пустой -f) не совпадает ни одна строка
+	expr := `[^\x00-\x{10FFFF}]`
+	if len(exprs) > 0 {
+		expr = strings.Join(exprs, "|")
+	}
+	if params.lineRegexp {
+		expr = "^(?:" + expr + ")$"
+	}
+	flags := ""
+	if params.ignoreCase {
+		flags = "(?i)"
+	}
+
+	re, err := regexp.Compile(flags + expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern: %w", err)
+	}
+	m := &regexMatcher{re: re}
+	if params.wordRegexp && !params.lineRegexp {
+		// \b в regexp учитывает только ASCII, поэтому конец слова задаём классом, как в isWordRune.
+		// Последняя группа - символ после слова, пользовательские группы идут до неё
+		m.wordRe, err = regexp.Compile(flags + "^(?:" + expr + `)([^\p{L}\p{Nd}_]|$)`)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// canUseFixed - побайтовый поиск корректен, если при -i регистр нужно сворачивать только у ASCII.
+// Пустой шаблон совпадает с любой строкой, это проще отдать регулярному выражению
+func canUseFixed(params parametres) bool {
+	if params.lineRegexp {
+		return true
+	}
+	for _, p := range params.patterns {
+		if p == "" {
+			return false
+		}
+		if !params.ignoreCase {
+			continue
+		}
+		for i := 0; i < len(p); i++ {
+			if p[i] >= utf8.RuneSelf {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+	// wordRe задан только для -w: шаблон, привязанный к началу строки и заканчивающийся на границе слова
+	wordRe *regexp.Regexp
+}
+
+func (m *regexMatcher) match(text string) bool {
+	if m.wordRe != nil {
+		return len(m.findAll(text)) > 0
+	}
+	return m.re.MatchString(text)
+}
+
+func (m *regexMatcher) findAll(text string) [][]int {
+	locs := m.re.FindAllStringIndex(text, -1)
+	if m.wordRe != nil {
+		return m.filterWordBounded(text, locs)
+	}
+	return locs
+}
+
+func (m *regexMatcher) findAllSubmatch(text string) [][]int {
+	locs := m.re.FindAllStringSubmatchIndex(text, -1)
+	if m.wordRe != nil {
+		return m.filterWordBounded(text, locs)
+	}
+	return locs
+}
+
+// filterWordBounded - как одноимённая функция, но совпадение, упирающееся в букву, ищется заново
+// с той же позиции: другая альтернатива шаблона может закончиться на границе слова ("ab|abc" в "abc")
+func (m *regexMatcher) filterWordBounded(text string, locs [][]int) [][]int {
+	res := locs[:0]
+	end := 0
+	for _, loc := range locs {
+		// повторный поиск мог захватить следующие совпадения
+		if loc[0] < end {
+			continue
+		}
+		if !isWordBounded(text, loc[0], loc[1]) {
+			if loc = m.wordMatchAt(text, loc[0], len(loc)); loc == nil {
+				continue
+			}
+		}
+		res = append(res, loc)
+		end = loc[1]
+	}
+	return res
+}
+
+// wordMatchAt ищет совпадение, которое начинается в start и ограничено границами слов.
+// width - сколько границ вернуть: 2 для findAll или все группы для findAllSubmatch
+func (m *regexMatcher) wordMatchAt(text string, start, width int) []int {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); isWordRune(r) {
+			return nil
+		}
+	}
+	loc := m.wordRe.FindStringSubmatchIndex(text[start:])
+	if loc == nil {
+		return nil
+	}
+
+	res := make([]int, width)
+	for i := range res {
+		if res[i] = loc[i]; res[i] >= 0 {
+			res[i] += start
+		}
+	}
+	// слово заканчивается там, где начинается символ после него
+	res[1] = loc[len(loc)-2] + start
+	return res
+}
+
+func (m *regexMatcher) groupNames() []string {
+	return m.re.SubexpNames()
+}
+
+type fixedMatcher struct {
+	patterns   []string
+	ignoreCase bool
+	wordRegexp bool
+
+	// для -x достаточно проверить строку целиком по множеству шаблонов
+	lines map[string]struct{}
+	ac    *ahoCorasick
+}
+
+func newFixedMatcher(params parametres) *fixedMatcher {
+	m := &fixedMatcher{
+		patterns:   params.patterns,
+		ignoreCase: params.ignoreCase,
+		wordRegexp: params.wordRegexp,
+	}
+	switch {
+	case params.lineRegexp:
+		m.lines = make(map[string]struct{}, len(params.patterns))
+		for _, p := range params.patterns {
+			m.lines[m.lineKey(p)] = struct{}{}
+		}
+	case len(params.patterns) >= ahoCorasickMinPatterns:
+		m.ac = newAhoCorasick(params.patterns, params.ignoreCase)
+	}
+	return m
+}
+
+func (m *fixedMatcher) lineKey(text string) string {
+	if m.ignoreCase {
+		return strings.ToLower(text)
+	}
+	return text
+}
+
+func (m *fixedMatcher) match(text string) bool {
+	switch {
+	case m.lines != nil:
+		_, ok := m.lines[m.lineKey(text)]
+		return ok
+	case m.wordRegexp:
+		return len(m.findAll(text)) > 0
+	case m.ac != nil:
+		return m.ac.contains(text)
+	case len(m.patterns) == 0:
+		return false
+	case m.ignoreCase:
+		return indexFoldASCII(text, m.patterns[0], 0) >= 0
+	default:
+		return strings.Contains(text, m.patterns[0])
+	}
+}
+
+func (m *fixedMatcher) findAll(text string) [][]int {
+	if m.lines != nil {
+		if m.match(text) {
+			return [][]int{{0, len(text)}}
+		}
+		return nil
+	}
+
+	var candidates [][]int
+	if m.ac != nil {
+		candidates = m.ac.candidates(text)
+	} else if len(m.patterns) == 1 {
+		candidates = m.singleCandidates(text)
+	}
+
+	if m.wordRegexp {
+		candidates = filterWordBounded(text, candidates)
+	}
+	return leftmostLongest(candidates)
+}
+
+// у фиксированных строк групп захвата нет
+func (m *fixedMatcher) findAllSubmatch(text string) [][]int {
+	return m.findAll(text)
+}
+
+func (m *fixedMatcher) groupNames() []string {
+	return []string{""}
+}
+
+// singleCandidates - все (в том числе пересекающиеся) вхождения единственного шаблона
+func (m *fixedMatcher) singleCandidates(text string) [][]int {
+	pattern := m.patterns[0]
+	var res [][]int
+	for start := 0; start <= len(text); {
+		var i int
+		if m.ignoreCase {
+			i = indexFoldASCII(text, pattern, start)
+		} else if i = strings.Index(text[start:], pattern); i >= 0 {
+			i += start
+		}
+		if i < 0 {
+			break
+		}
+		res = append(res, []int{i, i + len(pattern)})
+		start = i + 1
+	}
+	return res
+}
+
+// indexFoldASCII ищет pattern в text начиная с from без учёта регистра латинских букв
+func indexFoldASCII(text, pattern string, from int) int {
+	for i := from; i+len(pattern) <= len(text); i++ {
+		j := 0
+		for ; j < len(pattern); j++ {
+			if lowerASCII(text[i+j]) != lowerASCII(pattern[j]) {
+				break
+			}
+		}
+		if j == len(pattern) {
+			return i
+		}
+	}
+	return -1
+}
+
+// leftmostLongest выбирает из кандидатов непересекающиеся совпадения:
+// самое левое, а среди начинающихся в одной позиции - самое длинное
+func leftmostLongest(candidates [][]int) [][]int {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i][0] != candidates[j][0] {
+			return candidates[i][0] < candidates[j][0]
+		}
+		return candidates[i][1] > candidates[j][1]
+	})
+
+	var res [][]int
+	end := -1
+	for _, c := range candidates {
+		// пустое совпадение на месте конца предыдущего не считается отдельным
+		if c[0] < end || (c[0] == end && c[0] == c[1]) {
+			continue
+		}
+		res = append(res, c)
+		end = c[1]
+	}
+	return res
+}
+
+// filterWordBounded оставляет только совпадения, ограниченные границами слов (-w)
+func filterWordBounded(text string, locs [][]int) [][]int {
+	res := locs[:0]
+	for _, loc := range locs {
+		if isWordBounded(text, loc[0], loc[1]) {
+			res = append(res, loc)
+		}
+	}
+	return res
+}
+
+// isWordBounded - совпадение [start, end) не продолжается буквами, цифрами или "_" (как -w в GNU grep)
+func isWordBounded(text string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[end:])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// readPatternFile читает шаблоны из файла (-f), по одному на строку
+func readPatternFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return patterns, nil
+}
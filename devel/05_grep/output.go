@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+)
+
+// Цвета по умолчанию из GNU grep (GREP_COLORS='ms=01;31:fn=35:ln=32:bn=32:se=36')
+const (
+	colorMatch     = "01;31"
+	colorFilename  = "35"
+	colorLineNum   = "32"
+	colorByteOff   = "32"
+	colorSeparator = "36"
+)
+
+// Разделители после префиксов: ":" у выбранных строк, "-" у строк контекста
+const (
+	matchMarker   = ':'
+	contextMarker = '-'
+	groupSep      = "--"
+)
+
+// groupSeparatorLine - строка "--", которую searchFiles ставит между группами контекста из разных файлов.
+// nil, если группы контекста не печатаются
+func groupSeparatorLine(params parametres) []byte {
+	if (params.before == 0 && params.after == 0) || params.json || params.onlyMatching || params.toCount ||
+		params.listMatching || params.listNonMatching {
+		return nil
+	}
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	p := newPrinter(writer, params, nil, "")
+	p.colored(colorSeparator, groupSep)
+	writer.WriteByte('\n')
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// printer печатает строки одного файла в формате GNU grep:
+// [имя:][номер:][смещение:]строка, группы контекста разделяются "--"
+type printer struct {
+	writer   *bufio.Writer
+	params   parametres
+	m        matcher
+	filename string
+
+	// номер последней напечатанной строки, нужен для разделителей групп
+	lastNum int
+
+	// для --json: было ли уже напечатано begin и сколько найдено совпадений
+	begun   bool
+	matches int
+}
+
+func newPrinter(writer *bufio.Writer, params parametres, m matcher, filename string) *printer {
+	return &printer{writer: writer, params: params, m: m, filename: filename}
+}
+
+func (p *printer) colored(code, text string) {
+	if !p.params.color {
+		p.writer.WriteString(text)
+		return
+	}
+	p.writer.WriteString("\x1b[" + code + "m\x1b[K")
+	p.writer.WriteString(text)
+	p.writer.WriteString("\x1b[m\x1b[K")
+}
+
+func (p *printer) marker(marker byte) {
+	p.colored(colorSeparator, string(marker))
+}
+
+// prefix печатает имя файла, номер строки и байтовое смещение, если они включены
+func (p *printer) prefix(l line, offset int, marker byte) {
+	if p.params.withFilename {
+		p.colored(colorFilename, p.filename)
+		p.marker(marker)
+	}
+	if p.params.withLine {
+		p.colored(colorLineNum, strconv.Itoa(l.num))
+		p.marker(marker)
+	}
+	if p.params.byteOffset {
+		p.colored(colorByteOff, strconv.Itoa(offset))
+		p.marker(marker)
+	}
+}
+
+// printLine печатает выбранную строку (selected) или строку контекста
+func (p *printer) printLine(l line, selected bool) {
+	if p.params.json {
+		p.printJSONLine(l, selected)
+		return
+	}
+	if p.params.onlyMatching {
+		// С -o печатаются только сами совпадения, контекст не выводится
+		if selected {
+			p.printMatches(l)
+		}
+		return
+	}
+
+	if (p.params.before > 0 || p.params.after > 0) && p.lastNum > 0 && l.num > p.lastNum+1 {
+		p.colored(colorSeparator, groupSep)
+		p.writer.WriteByte('\n')
+	}
+	p.lastNum = l.num
+
+	marker := byte(contextMarker)
+	if selected {
+		marker = matchMarker
+	}
+	p.prefix(l, l.offset, marker)
+
+	// Совпадения подсвечиваются только в выбранных строках; при -v в них совпадений нет
+	if !selected || !p.params.color || p.params.invert {
+		p.writer.WriteString(l.text)
+		p.writer.WriteByte('\n')
+		return
+	}
+	last := 0
+	for _, span := range p.m.findAll(l.text) {
+		if span[0] == span[1] {
+			continue
+		}
+		p.writer.WriteString(l.text[last:span[0]])
+		p.colored(colorMatch, l.text[span[0]:span[1]])
+		last = span[1]
+	}
+	p.writer.WriteString(l.text[last:])
+	p.writer.WriteByte('\n')
+}
+
+// printMatches печатает каждое непустое совпадение строки отдельно (-o)
+func (p *printer) printMatches(l line) {
+	if p.params.invert {
+		return
+	}
+	for _, span := range p.m.findAll(l.text) {
+		if span[0] == span[1] {
+			continue
+		}
+		p.prefix(l, l.offset+span[0], matchMarker)
+		p.colored(colorMatch, l.text[span[0]:span[1]])
+		p.writer.WriteByte('\n')
+	}
+}
+
+// printFilename - вывод для -l/-L
+func (p *printer) printFilename() {
+	p.colored(colorFilename, p.filename)
+	p.writer.WriteByte('\n')
+}
+
+// printCount - вывод для -c
+func (p *printer) printCount(count int) {
+	if p.params.withFilename {
+		p.colored(colorFilename, p.filename)
+		p.marker(matchMarker)
+	}
+	p.writer.WriteString(strconv.Itoa(count))
+	p.writer.WriteByte('\n')
+}
+
+// printJSONLine печатает строку как объект match или context, перед первой строкой файла - begin
+func (p *printer) printJSONLine(l line, selected bool) {
+	if !p.begun {
+		p.begun = true
+		writeJSON(p.writer, "begin", jsonBegin{Path: newJSONData(p.filename)})
+	}
+
+	typ := "context"
+	submatches := []jsonSubmatch{}
+	if selected {
+		typ = "match"
+		if !p.params.invert {
+			submatches = jsonSubmatches(l.text, p.m.findAllSubmatch(l.text), p.m.groupNames())
+			p.matches += len(submatches)
+		}
+	}
+	writeJSON(p.writer, typ, jsonLine{
+		Path:           newJSONData(p.filename),
+		Lines:          newJSONData(l.text + "\n"),
+		LineNumber:     l.num,
+		AbsoluteOffset: l.offset,
+		Submatches:     submatches,
+	})
+}
+
+// printJSONEnd завершает вывод файла, в котором были найдены строки
+func (p *printer) printJSONEnd(stats searchStats) {
+	if p.begun {
+		writeJSON(p.writer, "end", jsonEnd{Path: newJSONData(p.filename), Stats: stats})
+	}
+}
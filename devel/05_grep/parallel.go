@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// Размер куска файла для параллельного поиска; границы кусков выравниваются по переводу строки
+const parallelChunkSize = 32 << 20
+
+// keptLine - строка куска, которая может попасть в вывод
+type keptLine struct {
+	line
+	isMatch bool
+}
+
+// chunkResult - итог обработки одного куска. Номера строк и смещения в kept - локальные
+type chunkResult struct {
+	start   int64
+	size    int64
+	lines   int
+	matched int
+	kept    []keptLine
+	err     error
+}
+
+// canGrepParallel - параллельный режим нужен только там, где результат
+// не зависит от последовательного чтения: без распаковки, перекодирования, -m и -l/-L
+func canGrepParallel(params parametres) bool {
+	return params.jobs > 1 && !params.decompress && params.encoding == "" &&
+		params.maxCount == 0 && !params.listMatching && !params.listNonMatching
+}
+
+// grepParallel делит файл на куски, выровненные по строкам, и ищет в них params.jobs горутинами.
+// Каждая горутина сохраняет только строки, которые могут быть напечатаны: окрестности своих
+// совпадений и первые -A / последние -B строк куска (контекст для совпадений из соседних кусков).
+// Затем куски по порядку проходят через ту же логику контекста, что и в grepStream,
+// но по глобальным номерам строк, поэтому вывод совпадает с последовательным
+func grepParallel(file *os.File, size int64, chunkSize int64, filename string, params parametres, m matcher, writer *bufio.Writer) (searchStats, error) {
+	checker := newChecker(m, params.invert)
+	out := newPrinter(writer, params, m, filename)
+
+	chunks := int((size + chunkSize - 1) / chunkSize)
+	window := make(chan struct{}, 2*params.jobs)
+	results := make([]chan chunkResult, chunks)
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < chunks; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			jobs <- i
+		}
+	}()
+	for w := 0; w < params.jobs; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] <- grepChunk(file, size, int64(i)*chunkSize, int64(i+1)*chunkSize, params, checker)
+			}
+		}()
+	}
+
+	before := newLineRing(params.before)
+	afterUntil := 0
+	stats := searchStats{Searches: 1, BytesSearched: int(size)}
+
+	emit := func(l line, selected bool) {
+		if !params.toCount {
+			out.printLine(l, selected)
+		}
+	}
+
+	base := 0
+	for i := 0; i < chunks; i++ {
+		res := <-results[i]
+		<-window
+		if res.err != nil {
+			return stats, res.err
+		}
+
+		for _, k := range res.kept {
+			cur := k.line
+			cur.num += base
+			cur.offset += int(res.start)
+
+			switch {
+			case k.isMatch:
+				// в кольце могут оказаться строки дальше -B, если между ними были пропуски
+				for _, l := range before.drain() {
+					if l.num >= cur.num-params.before {
+						emit(l, false)
+					}
+				}
+				emit(cur, true)
+				afterUntil = cur.num + params.after
+			case cur.num <= afterUntil:
+				emit(cur, false)
+			default:
+				before.push(cur)
+			}
+		}
+
+		base += res.lines
+		stats.MatchedLines += res.matched
+
+		// Отдаём найденное по мере готовности кусков
+		if err := writer.Flush(); err != nil {
+			return stats, err
+		}
+	}
+
+	if params.toCount {
+		out.printCount(stats.MatchedLines)
+	}
+	stats.Matches = out.matches
+	if stats.MatchedLines > 0 {
+		stats.SearchesWithMatch = 1
+	}
+	if params.json {
+		out.printJSONEnd(stats)
+	}
+	return stats, nil
+}
+
+// grepChunk обрабатывает строки, начинающиеся в [from, to) после выравнивания по переводу строки
+func grepChunk(file *os.File, size, from, to int64, params parametres, checker func(string) bool) chunkResult {
+	start, err := alignToLine(file, from)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	end, err := alignToLine(file, min(to, size))
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	res := chunkResult{start: start, size: end - start}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, start, end-start))
+	before := newLineRing(params.before)
+	afterUntil := 0
+	offset := 0
+
+	for num := 1; ; num++ {
+		text, n, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return chunkResult{err: err}
+		}
+		cur := line{num: num, offset: offset, text: text}
+		offset += n
+		res.lines++
+
+		isMatch := checker(text)
+		switch {
+		case isMatch:
+			res.matched++
+			for _, l := range before.drain() {
+				res.kept = append(res.kept, keptLine{line: l})
+			}
+			res.kept = append(res.kept, keptLine{line: cur, isMatch: true})
+			afterUntil = num + params.after
+		case num <= afterUntil || num <= params.after:
+			// первые -A строк нужны совпадениям из предыдущих кусков
+			res.kept = append(res.kept, keptLine{line: cur})
+		default:
+			before.push(cur)
+		}
+	}
+
+	// последние -B строк нужны совпадениям из следующих кусков
+	for _, l := range before.drain() {
+		res.kept = append(res.kept, keptLine{line: l})
+	}
+	return res
+}
+
+// alignToLine возвращает начало первой строки, начинающейся не раньше pos
+func alignToLine(file *os.File, pos int64) (int64, error) {
+	if pos == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 64<<10)
+	for cur := pos - 1; ; {
+		n, err := file.ReadAt(buf, cur)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return cur + int64(i) + 1, nil
+		}
+		cur += int64(n)
+		if errors.Is(err, io.EOF) {
+			return cur, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
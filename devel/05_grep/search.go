@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// maxBufferedOutput - сколько вывода файл может накопить, пока ждёт своей очереди на печать
+const maxBufferedOutput = 1 << 20
+
+// fileResult - итог поиска по одному файлу. Сам вывод идёт через orderedOutput
+type fileResult struct {
+	stats searchStats
+	err   error
+}
+
+// searchFiles ищет по файлам параллельно, но печатает результаты строго в порядке files,
+// чтобы вывод не перемешивался. Ошибки по отдельным файлам передаются в onError
+func searchFiles(files []string, params parametres, out io.Writer, onError func(error)) error {
+	m, err := newMatcher(params)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	workers := min(runtime.NumCPU(), len(files))
+	output := newOrderedOutput(out, len(files), maxBufferedOutput)
+	output.separator = groupSeparatorLine(params)
+
+	// Ограничиваем число файлов, обработанных, но ещё не напечатанных, чтобы не держать всё в памяти
+	window := make(chan struct{}, 2*workers)
+	results := make([]chan fileResult, len(files))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range files {
+			window <- struct{}{}
+			jobs <- i
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var res fileResult
+				res.stats, res.err = grepFile(files[i], params, m, output.file(i))
+				results[i] <- res
+			}
+		}()
+	}
+
+	var total searchStats
+	for i := range files {
+		res := <-results[i]
+		<-window
+		if res.err != nil {
+			onError(res.err)
+		}
+		total.add(res.stats)
+		output.next()
+	}
+	wg.Wait()
+
+	writeErr := output.err
+	if writeErr == nil && params.json {
+		writeErr = writeJSONSummary(out, total, time.Since(start))
+	}
+	return writeErr
+}
+
+// orderedOutput печатает вывод файлов в порядке их номеров. Файл, чья очередь печати подошла,
+// пишет сразу в out, остальные копят вывод в буфере. Когда буфер файла превышает limit,
+// его запись ждёт очереди: так память ограничена, а первый в очереди файл никогда не ждёт
+type orderedOutput struct {
+	out   io.Writer
+	limit int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// номер файла, который сейчас печатается
+	head  int
+	files []orderedFile
+	// первая ошибка записи в out; после неё вывод отбрасывается
+	err error
+
+	// separator печатается перед выводом файла, если до него уже что-то напечатано:
+	// группы контекста разных файлов разделяются так же, как группы внутри файла
+	separator []byte
+	printed   bool
+}
+
+type orderedFile struct {
+	o     *orderedOutput
+	index int
+	buf   bytes.Buffer
+	// начал ли файл печатать в out
+	started bool
+}
+
+func newOrderedOutput(out io.Writer, n, limit int) *orderedOutput {
+	o := &orderedOutput{out: out, limit: limit, files: make([]orderedFile, n)}
+	o.cond = sync.NewCond(&o.mu)
+	for i := range o.files {
+		o.files[i] = orderedFile{o: o, index: i}
+	}
+	return o
+}
+
+// file возвращает writer для вывода i-го файла
+func (o *orderedOutput) file(i int) io.Writer {
+	return &o.files[i]
+}
+
+// next вызывается, когда текущий файл записал весь вывод: очередь переходит к следующему,
+// и накопленный им буфер печатается
+func (o *orderedOutput) next() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.head++
+	if o.head < len(o.files) {
+		f := &o.files[o.head]
+		o.emit(f, f.buf.Bytes())
+		f.buf = bytes.Buffer{}
+	}
+	o.cond.Broadcast()
+}
+
+// emit печатает вывод файла f, ставя перед первым выводом файла разделитель
+func (o *orderedOutput) emit(f *orderedFile, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	if !f.started {
+		f.started = true
+		if o.printed {
+			o.write(o.separator)
+		}
+		o.printed = true
+	}
+	o.write(p)
+}
+
+func (o *orderedOutput) write(p []byte) {
+	if o.err == nil && len(p) > 0 {
+		_, o.err = o.out.Write(p)
+	}
+}
+
+func (f *orderedFile) Write(p []byte) (int, error) {
+	o := f.o
+	o.mu.Lock()
+	for f.index != o.head && f.buf.Len() > 0 && f.buf.Len()+len(p) > o.limit {
+		o.cond.Wait()
+	}
+	if f.index != o.head {
+		f.buf.Write(p)
+		o.mu.Unlock()
+		return len(p), nil
+	}
+	o.mu.Unlock()
+
+	// Очередь сменится только после того, как файл допишет весь вывод, поэтому писать в out
+	// можно без блокировки и не задерживать файлы, которые копят вывод
+	o.emit(f, p)
+	return len(p), nil
+}
+
+// grepFile ищет в одном файле. Двоичные файлы пропускаются
+func grepFile(name string, params parametres, m matcher, out io.Writer) (searchStats, error) {
+	if canGrepParallel(params) {
+		return grepFileParallel(name, params, m, out)
+	}
+
+	reader, closer, skip, err := openFile(name, params)
+	if err != nil || skip {
+		return searchStats{}, err
+	}
+	defer closer.Close()
+
+	writer := bufio.NewWriter(out)
+	stats, err := grepStream(reader, name, params, m, writer)
+	if err != nil {
+		return stats, err
+	}
+	return stats, writer.Flush()
+}
+
+func grepFileParallel(name string, params parametres, m matcher, out io.Writer) (searchStats, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return searchStats{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return searchStats{}, err
+	}
+	// Канал или FIFO (например, <(cat big)) нельзя читать через ReadAt, поэтому ищем в нём последовательно
+	if !info.Mode().IsRegular() {
+		buffered := bufio.NewReader(file)
+		if isBinary(buffered) {
+			return searchStats{}, nil
+		}
+		writer := bufio.NewWriter(out)
+		stats, err := grepStream(buffered, name, params, m, writer)
+		if err != nil {
+			return stats, err
+		}
+		return stats, writer.Flush()
+	}
+
+	head := make([]byte, binaryCheckSize)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return searchStats{}, err
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return searchStats{}, nil
+	}
+
+	writer := bufio.NewWriter(out)
+	stats, err := grepParallel(file, info.Size(), parallelChunkSize, name, params, m, writer)
+	if err != nil {
+		return stats, fmt.Errorf("%s: %w", name, err)
+	}
+	return stats, writer.Flush()
+}
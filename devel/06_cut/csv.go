@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// csvColumn - элемент списка -f в режиме --csv: имя колонки или диапазон номеров.
+// Элемент, похожий на номер (например, "2024"), сначала ищется среди имён заголовка
+type csvColumn struct {
+	name    string
+	isRange bool
+	r       listRange
+}
+
+// parseColumns разбирает "-f name,3,5-" для --csv. В отличие от обычного режима порядок
+// сохраняется: колонки выводятся так, как перечислены
+func parseColumns(value string) ([]csvColumn, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+	var columns []csvColumn
+	for _, item := range strings.Split(value, ",") {
+		if item == "" {
+			return nil, fmt.Errorf("empty column name in %q", value)
+		}
+		// всё, что не похоже на номер или диапазон, - имя колонки
+		if strings.Trim(item, "0123456789-") != "" {
+			columns = append(columns, csvColumn{name: item})
+			continue
+		}
+		r, err := parseRange(item)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, csvColumn{name: item, isRange: true, r: r})
+	}
+	return columns, nil
+}
+
+// resolveColumns возвращает номера выбранных колонок (с 0) по заголовку. Точное совпадение
+// с именем в заголовке важнее номера. Диапазоны обрезаются по ширине заголовка
+func resolveColumns(columns []csvColumn, header []string, complement bool) ([]int, error) {
+	var res []int
+	for _, c := range columns {
+		if i := slices.Index(header, c.name); i >= 0 {
+			res = append(res, i)
+			continue
+		}
+		if !c.isRange {
+			return nil, fmt.Errorf("unknown column %q", c.name)
+		}
+		to := c.r.to
+		if to == 0 || to > len(header) {
+			to = len(header)
+		}
+		for i := c.r.from; i <= to; i++ {
+			res = append(res, i-1)
+		}
+	}
+
+	if !complement {
+		return res, nil
+	}
+	var rest []int
+	for i := range header {
+		if !slices.Contains(res, i) {
+			rest = append(rest, i)
+		}
+	}
+	return rest, nil
+}
+
+// doCutCSV выбирает колонки из CSV по RFC 4180: поля в кавычках могут содержать разделитель
+// и переводы строк. Первая запись - заголовок; вывод снова экранируется как CSV
+func doCutCSV(in io.Reader, params parametres, out io.Writer) error {
+	reader := csv.NewReader(in)
+	reader.Comma = params.csvComma
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	writer := csv.NewWriter(out)
+	writer.Comma = params.csvOutputComma
+
+	var columns []int
+	selected := []string{}
+	for isHeader := true; ; isHeader = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writer.Flush()
+			return err
+		}
+
+		if isHeader {
+			if columns, err = resolveColumns(params.csvColumns, record, params.complement); err != nil {
+				return err
+			}
+			if params.skipHeader {
+				continue
+			}
+		}
+
+		selected = selected[:0]
+		for _, i := range columns {
+			// недостающие поля считаются пустыми
+			value := ""
+			if i < len(record) {
+				value = record[i]
+			}
+			selected = append(selected, value)
+		}
+		writer.Write(selected)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
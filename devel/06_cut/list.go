@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listRange - диапазон номеров полей, байт или символов; to == 0 - до конца строки
+type listRange struct {
+	from, to int
+}
+
+// list - выбранные номера: отсортированные непересекающиеся диапазоны. Соседние диапазоны
+// (1-2,3-4) не объединяются: как в GNU cut, между ними выводится --output-delimiter
+type list []listRange
+
+// parseList разбирает список в синтаксисе POSIX: "1,3-5,7-", "-3" означает "1-3"
+func parseList(value string) (list, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+
+	var res list
+	for _, item := range strings.Split(value, ",") {
+		r, err := parseRange(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	// Пересекающиеся диапазоны объединяются: каждый номер выводится один раз и в порядке входа
+	sort.Slice(res, func(i, j int) bool { return res[i].from < res[j].from })
+	merged := res[:1]
+	for _, r := range res[1:] {
+		last := &merged[len(merged)-1]
+		switch {
+		case last.to == 0:
+		case r.from <= last.to:
+			if r.to == 0 || r.to > last.to {
+				last.to = r.to
+			}
+		default:
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
+
+func parseRange(item string) (listRange, error) {
+	number := func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("numbers are numbered from 1")
+		}
+		return n, nil
+	}
+
+	from, to, isRange := strings.Cut(item, "-")
+	if !isRange {
+		n, err := number(item)
+		return listRange{from: n, to: n}, err
+	}
+	if from == "" && to == "" {
+		return listRange{}, fmt.Errorf("invalid range with no endpoint: -")
+	}
+
+	r := listRange{from: 1}
+	var err error
+	if from != "" {
+		if r.from, err = number(from); err != nil {
+			return listRange{}, err
+		}
+	}
+	if to != "" {
+		if r.to, err = number(to); err != nil {
+			return listRange{}, err
+		}
+		if r.to < r.from {
+			return listRange{}, fmt.Errorf("invalid decreasing range %q", item)
+		}
+	}
+	return r, nil
+}
+
+// complement - все номера, не вошедшие в список (--complement)
+func (l list) complement() list {
+	var res list
+	next := 1
+	for _, r := range l {
+		if r.from > next {
+			res = append(res, listRange{from: next, to: r.from - 1})
+		}
+		if r.to == 0 {
+			return res
+		}
+		next = r.to + 1
+	}
+	return append(res, listRange{from: next})
+}
+
+// index возвращает номер диапазона, в который входит n (с 1), или -1
+func (l list) index(n int) int {
+	for i, r := range l {
+		if n < r.from {
+			return -1
+		}
+		if r.to == 0 || n <= r.to {
+			return i
+		}
+	}
+	return -1
+}
+
+// last - наибольший номер в списке, 0 - список не ограничен справа
+func (l list) last() int {
+	if len(l) == 0 {
+		return 0
+	}
+	return l[len(l)-1].to
+}
@@ -2,21 +2,54 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
-	"strconv"
+	"regexp"
 	"strings"
+	"unicode/utf8"
+)
+
+// cutMode - что выбирает список: поля, байты или символы
+type cutMode int
+
+const (
+	modeFields cutMode = iota
+	modeBytes
+	modeChars
 )
 
 type parametres struct {
-	fields        []int
+	mode cutMode
+	// с --complement здесь уже дополнение списка из аргументов
+	list list
+
+	// разделитель полей: строка любой длины или регулярное выражение (-regex)
 	delim         string
+	delimRegexp   *regexp.Regexp
 	withDelimOnly bool
 
-	filename string
+	// --output-delimiter; без него поля разделяются исходным разделителем, а байты и символы - ничем
+	outputDelim    string
+	hasOutputDelim bool
+
+	// -z: строки завершаются NUL, а не переводом строки
+	zeroTerminated bool
+
+	// --csv: колонки выбираются из записей CSV, в списке можно указывать имена из заголовка
+	csv            bool
+	csvColumns     []csvColumn
+	csvComma       rune
+	csvOutputComma rune
+	// для --csv дополнение считается по заголовку, поэтому хранится отдельно от list
+	complement bool
+	// заголовок печатается только для первого файла
+	skipHeader bool
+
+	// без файлов и для "-" читается стандартный ввод
+	filenames []string
 }
 
 func main() {
@@ -27,105 +60,340 @@ func main() {
 		return
 	}
 
-	data, err := readData(params.filename)
-	if err != nil {
-		fmt.Println("input error: ", err.Error())
-		return
+	// Ошибка одного файла не прерывает обработку остальных, как в GNU cut
+	failed := false
+	for i, name := range params.filenames {
+		params.skipHeader = i > 0
+		if err := cutFile(name, params, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "input error:", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
-
-	doCut(data, params, os.Stdout)
 }
 
 func parseArgsIntoParams() (parametres, error) {
-	fields := flag.String("f", "", "Choose fileds")
-	delim := flag.String("d", "", "Choose delim")
-	isSep := flag.Bool("s", false, "Separated parametr")
+	return parseArgs(os.Args[1:])
+}
 
-	flag.Parse()
+func parseArgs(args []string) (parametres, error) {
+	flag := flag.NewFlagSet("cut", flag.ContinueOnError)
+	fields := flag.String("f", "", "Select only these fields: LIST like 1,3-5,7-")
+	bytesList := flag.String("b", "", "Select only these bytes: LIST like 1,3-5,7-")
+	chars := flag.String("c", "", "Select only these characters (runes): LIST like 1,3-5,7-")
+	delim := flag.String("d", "", "Use DELIM instead of TAB for field delimiter, may be longer than one character")
+	isRegexp := flag.Bool("regex", false, "Treat -d as a regular expression")
+	isSep := flag.Bool("s", false, "Do not print lines not containing delimiters")
+	complement := flag.Bool("complement", false, "Complement the set of selected bytes, characters or fields")
+	zeroTerminated := flag.Bool("z", false, "Line delimiter is NUL, not newline")
+	isCSV := flag.Bool("csv", false, "Parse input as CSV with a header row; -f may list column names in any order (a header name wins over a column number)")
+	var outputDelim *string
+	flag.Func("output-delimiter", "Use STRING as the output delimiter", func(value string) error {
+		outputDelim = &value
+		return nil
+	})
 
-	// Для флага -f нужно извлечь допонительные данные
-	// Сразу же проверяем уникальность параметров от пользователя
-	uniqueColumns := make(map[int]struct{})
-	for _, column := range strings.Split(*fields, " ") {
-		num, err := strconv.Atoi(column)
-		if err != nil {
-			return parametres{}, fmt.Errorf("column -f error")
+	if err := flag.Parse(expandShortFlags(flag, args)); err != nil {
+		return parametres{}, err
+	}
+
+	params := parametres{
+		delim:          "\t",
+		withDelimOnly:  *isSep,
+		zeroTerminated: *zeroTerminated,
+	}
+
+	// Должен быть задан ровно один список
+	var value string
+	lists := 0
+	for mode, v := range map[cutMode]string{modeFields: *fields, modeBytes: *bytesList, modeChars: *chars} {
+		if v != "" {
+			params.mode, value = mode, v
+			lists++
 		}
-		if num < 1 {
-			return parametres{}, fmt.Errorf("Field must be at least 1.")
+	}
+	switch lists {
+	case 0:
+		return parametres{}, fmt.Errorf("you must specify a list of bytes, characters, or fields")
+	case 1:
+	default:
+		return parametres{}, fmt.Errorf("only one type of list may be specified")
+	}
+	if *isCSV {
+		return parseCSVArgs(params, *delim, outputDelim, *complement, *isRegexp || *isSep || *zeroTerminated, value, flag.Args())
+	}
+
+	var err error
+	if params.list, err = parseList(value); err != nil {
+		return parametres{}, err
+	}
+	if *complement {
+		params.list = params.list.complement()
+	}
+
+	if params.mode != modeFields && (*delim != "" || *isRegexp) {
+		return parametres{}, fmt.Errorf("an input delimiter may be specified only when operating on fields")
+	}
+	if params.mode != modeFields && *isSep {
+		return parametres{}, fmt.Errorf("suppressing non-delimited lines makes sense only when operating on fields")
+	}
+	if *delim != "" {
+		params.delim = *delim
+	}
+	if *isRegexp {
+		if params.delimRegexp, err = regexp.Compile(params.delim); err != nil {
+			return parametres{}, err
 		}
-		uniqueColumns[num] = struct{}{}
 	}
-	columns := make([]int, 0, len(uniqueColumns))
-	for unColumn := range uniqueColumns {
-		columns = append(columns, unColumn-1)
+	if outputDelim != nil {
+		params.outputDelim, params.hasOutputDelim = *outputDelim, true
 	}
-	sort.Ints(columns)
+	params.filenames = inputFiles(flag.Args())
 
-	// проверяем, что введён один символ
-	if len(*delim) != 1 {
-		return parametres{}, fmt.Errorf("Delim should be with size 1")
+	return params, nil
+}
+
+func parseCSVArgs(params parametres, delim string, outputDelim *string, complement, hasLineOptions bool, value string, args []string) (parametres, error) {
+	if params.mode != modeFields {
+		return parametres{}, fmt.Errorf("--csv selects only fields")
 	}
-	var filename string
-	if len(flag.Args()) == 1 {
-		filename = flag.Args()[0]
+	if hasLineOptions {
+		return parametres{}, fmt.Errorf("options -regex, -s and -z are not supported with --csv")
 	}
 
-	params := parametres{
-		fields:        columns,
-		delim:         *delim,
-		withDelimOnly: *isSep,
-		filename:      filename,
+	single := func(value, name string) (rune, error) {
+		if utf8.RuneCountInString(value) != 1 {
+			return 0, fmt.Errorf("%s must be a single character with --csv: %q", name, value)
+		}
+		r, _ := utf8.DecodeRuneInString(value)
+		return r, nil
 	}
 
+	var err error
+	params.csv = true
+	params.complement = complement
+	params.csvComma = ','
+	if delim != "" {
+		if params.csvComma, err = single(delim, "delimiter"); err != nil {
+			return parametres{}, err
+		}
+	}
+	params.csvOutputComma = params.csvComma
+	if outputDelim != nil {
+		if params.csvOutputComma, err = single(*outputDelim, "output delimiter"); err != nil {
+			return parametres{}, err
+		}
+	}
+	if params.csvColumns, err = parseColumns(value); err != nil {
+		return parametres{}, err
+	}
+	params.filenames = inputFiles(args)
 	return params, nil
 }
 
-func readData(filename string) ([]string, error) {
-	var input io.Reader
-	if filename == "" {
-		input = os.Stdin
-	} else {
-		file, err := os.Open(filename)
+func inputFiles(args []string) []string {
+	if len(args) == 0 {
+		return []string{"-"}
+	}
+	return args
+}
+
+func cutFile(name string, params parametres, out io.Writer) error {
+	cut := doCut
+	if params.csv {
+		cut = doCutCSV
+	}
+
+	if name == "-" {
+		return cut(os.Stdin, params, out)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := cut(file, params, out); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// doCut построчно обрабатывает поток, не загружая его целиком; длина строки не ограничена
+func doCut(in io.Reader, params parametres, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+	terminator := params.terminator()
+
+	for {
+		line, err := reader.ReadString(terminator)
+		if line != "" {
+			line = strings.TrimSuffix(line, string(terminator))
+			if params.mode != modeFields {
+				cutUnits(writer, line, params)
+				writer.WriteByte(terminator)
+			} else if cutFields(writer, line, params) {
+				writer.WriteByte(terminator)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return writer.Flush()
+		}
 		if err != nil {
-			return []string{}, err
+			writer.Flush()
+			return err
 		}
-		defer file.Close()
 	}
+}
 
-	scanner := bufio.NewScanner(input)
-	lines := []string{}
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+func (p parametres) terminator() byte {
+	if p.zeroTerminated {
+		return 0
 	}
-	if err := scanner.Err(); err != nil {
-		return []string{}, err
+	return '\n'
+}
+
+// cutFields печатает выбранные поля строки. false - строка без разделителя пропущена (-s).
+// Поля перебираются по месту, без разбиения строки в срез
+func cutFields(w *bufio.Writer, line string, params parametres) bool {
+	// для регулярного выражения все разделители ищутся сразу, пустые совпадения не разделяют поля
+	var seps [][]int
+	if params.delimRegexp != nil {
+		for _, loc := range params.delimRegexp.FindAllStringIndex(line, -1) {
+			if loc[0] != loc[1] {
+				seps = append(seps, loc)
+			}
+		}
 	}
-	return lines, nil
+
+	last := params.list.last()
+	sep, printed := "", false
+	for n, pos := 1, 0; ; n++ {
+		// поле n - line[pos:end], следующее начинается с next
+		end, next := len(line), -1
+		if params.delimRegexp != nil {
+			if n <= len(seps) {
+				end, next = seps[n-1][0], seps[n-1][1]
+			}
+		} else if i := strings.Index(line[pos:], params.delim); i >= 0 {
+			end, next = pos+i, pos+i+len(params.delim)
+		}
+
+		if n == 1 && next < 0 {
+			if params.withDelimOnly {
+				return false
+			}
+			w.WriteString(line)
+			return true
+		}
+
+		if params.list.index(n) >= 0 {
+			if printed {
+				if params.hasOutputDelim {
+					sep = params.outputDelim
+				}
+				w.WriteString(sep)
+			}
+			w.WriteString(line[pos:end])
+			printed = true
+			if next >= 0 {
+				sep = line[end:next]
+			}
+		}
+
+		// дальше выбранных полей нет
+		if next < 0 || (last != 0 && n >= last) {
+			return true
+		}
+		pos = next
+	}
+}
+
+// cutUnits печатает выбранные байты (-b) или символы (-c) диапазонами целиком. Между
+// диапазонами списка выводится --output-delimiter, если он задан
+func cutUnits(w *bufio.Writer, line string, params parametres) {
+	chars := params.mode == modeChars
+	pos, n := 0, 1
+	printed := false
+	for _, r := range params.list {
+		start := advance(line, pos, r.from-n, chars)
+		if start >= len(line) {
+			return
+		}
+		end := len(line)
+		if r.to != 0 {
+			end = advance(line, start, r.to-r.from+1, chars)
+		}
+
+		if printed && params.hasOutputDelim {
+			w.WriteString(params.outputDelim)
+		}
+		w.WriteString(line[start:end])
+		printed = true
+
+		if r.to == 0 {
+			return
+		}
+		pos, n = end, r.to+1
+	}
+}
+
+// advance сдвигает позицию в строке на count байт или символов, не выходя за её конец
+func advance(line string, pos, count int, chars bool) int {
+	if !chars {
+		return min(pos+count, len(line))
+	}
+	for ; count > 0 && pos < len(line); count-- {
+		_, size := utf8.DecodeRuneInString(line[pos:])
+		pos += size
+	}
+	return pos
 }
 
-func doCut(data []string, params parametres, out io.Writer) {
-	for i := 0; i < len(data); i++ {
-		isDelimExists := strings.Contains(data[i], params.delim)
-		if params.withDelimOnly && !isDelimExists {
+// expandShortFlags разрешает запись в стиле GNU: "-d:" превращается в "-d :",
+// "-sf1" - в "-s -f 1". Известные флаги (-regex) и значения флагов не меняются
+func expandShortFlags(flags *flag.FlagSet, args []string) []string {
+	takesValue := func(f *flag.Flag) bool {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		return !(ok && b.IsBoolFlag())
+	}
+
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			res = append(res, arg)
+			if arg == "--" {
+				return append(res, args[i+1:]...)
+			}
 			continue
 		}
-		if !params.withDelimOnly && !isDelimExists {
-			// fmt.Fprintf(out, "!Conctine %v!\n", isDelimExists)
-			fmt.Fprintf(out, "%s\n", data[i])
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if f := flags.Lookup(name); f != nil || strings.HasPrefix(arg, "--") {
+			res = append(res, arg)
+			// значение в следующем аргументе может начинаться с "-": "-b -2"
+			if f != nil && !hasValue && takesValue(f) && i+1 < len(args) {
+				i++
+				res = append(res, args[i])
+			}
 			continue
 		}
 
-		columnStrs := strings.Split(data[i], params.delim)
-		for _, colNum := range params.fields {
-			if colNum >= len(columnStrs) {
+		for j := 1; j < len(arg); j++ {
+			f := flags.Lookup(arg[j : j+1])
+			res = append(res, "-"+arg[j:j+1])
+			if f != nil && takesValue(f) {
+				if j+1 < len(arg) {
+					res = append(res, arg[j+1:])
+				} else if i+1 < len(args) {
+					i++
+					res = append(res, args[i])
+				}
 				break
 			}
-			fmt.Fprintf(out, "%s", columnStrs[colNum])
-			if colNum != len(params.fields)-1 {
-				fmt.Fprintf(out, "%s", params.delim)
-			}
 		}
-		fmt.Fprint(out, "\n")
 	}
+	return res
 }
@@ -3,7 +3,11 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -28,6 +32,15 @@ func readFileOrPanic(filename string) []string {
 	return lines
 }
 
+func joinLines(lines []string, terminator byte) io.Reader {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte(terminator)
+	}
+	return strings.NewReader(b.String())
+}
+
 func Test_doCut(t *testing.T) {
 	type args struct {
 		data   []string
@@ -42,7 +55,7 @@ func Test_doCut(t *testing.T) {
 			name: "Delim space First column",
 			args: args{
 				data:   readFileOrPanic("file.txt"),
-				params: parametres{delim: " ", fields: []int{0}},
+				params: parametres{delim: " ", list: list{{1, 1}}},
 			},
 			wantOut: strings.Join(readFileOrPanic("file_ans_1.txt"), "\n"),
 		},
@@ -50,7 +63,7 @@ func Test_doCut(t *testing.T) {
 			name: "Delim space First column",
 			args: args{
 				data:   readFileOrPanic("file.txt"),
-				params: parametres{delim: ":", fields: []int{0, 1}, withDelimOnly: true},
+				params: parametres{delim: ":", list: list{{1, 2}}, withDelimOnly: true},
 			},
 			wantOut: strings.Join(readFileOrPanic("file_ans_2.txt"), "\n"),
 		},
@@ -58,7 +71,7 @@ func Test_doCut(t *testing.T) {
 			name: "Delim space First column",
 			args: args{
 				data:   readFileOrPanic("file.txt"),
-				params: parametres{delim: " ", fields: []int{0, 1, 2}, withDelimOnly: true},
+				params: parametres{delim: " ", list: list{{1, 3}}, withDelimOnly: true},
 			},
 			wantOut: strings.Join(readFileOrPanic("file_ans_3.txt"), "\n"),
 		},
@@ -67,10 +80,397 @@ func Test_doCut(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			out := &bytes.Buffer{}
-			doCut(tt.args.data, tt.args.params, out)
+			if err := doCut(joinLines(tt.args.data, '\n'), tt.args.params, out); err != nil {
+				t.Fatal(err)
+			}
 			if gotOut := out.String()[:len(out.String())-1]; gotOut != tt.wantOut {
 				t.Errorf("doGrep() = %v, want %v", gotOut, tt.wantOut)
 			}
 		})
 	}
 }
+
+func Test_parseList(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    list
+		wantErr bool
+	}{
+		{value: "1,3-5,7-", want: list{{1, 1}, {3, 5}, {7, 0}}},
+		{value: "-3", want: list{{1, 3}}},
+		{value: "5-,2,1-3", want: list{{1, 3}, {5, 0}}},
+		{value: "1-2,3-4", want: list{{1, 2}, {3, 4}}},
+		{value: "2-8,3-,4", want: list{{2, 0}}},
+		{value: "", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "-", wantErr: true},
+		{value: "5-3", wantErr: true},
+		{value: "1,,2", wantErr: true},
+		{value: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseList(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := (list{{2, 3}, {5, 5}}).complement(), (list{{1, 1}, {4, 4}, {6, 0}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("complement() = %v, want %v", got, want)
+	}
+}
+
+// Ожидаемый вывод получен GNU cut на testdata/conformance.txt
+func Test_doCut_conformance(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"-f", "2"}, want: "first second third\n1 2 3\nb\nno delimiter here\nleading tab\n\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-f", "1,3"}, want: "first second third\n1 2 3\na\tc\nno delimiter here\n\ntrailing tab\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-f", "2-"}, want: "first second third\n1 2 3\nb\tc\td\nno delimiter here\nleading tab\n\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-f", "-2"}, want: "first second third\n1 2 3\na\tb\nno delimiter here\n\tleading tab\ntrailing tab\t\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-f", "3,1-2"}, want: "first second third\n1 2 3\na\tb\tc\nno delimiter here\n\tleading tab\ntrailing tab\t\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-f", "1", "-s"}, want: "a\n\ntrailing tab\n"},
+		{args: []string{"-d", " ", "-f", "2-3"}, want: "second third\n2 3\na\tb\tc\td\ndelimiter here\ntab\ntab\t\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-d", " ", "-f", "1,3", "-s"}, want: "first third\n1 3\nno here\n\tleading\ntrailing\n"},
+		{args: []string{"-d", " ", "-f", "2", "--complement"}, want: "first third\n1 3\na\tb\tc\td\nno here\n\tleading\ntrailing\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-d", ":", "-f", "1,3"}, want: "first second third\n1 2 3\na\tb\tc\td\nno delimiter here\n\tleading tab\ntrailing tab\t\nx:y\none:\nabcdefghij\n\n"},
+		{args: []string{"-d", ":", "-f", "2-", "-s"}, want: ":y::z\ntwo::three:\n"},
+		{args: []string{"-d", ":", "-f", "1,4", "--output-delimiter=|"}, want: "first second third\n1 2 3\na\tb\tc\td\nno delimiter here\n\tleading tab\ntrailing tab\t\nx|\none|three\nabcdefghij\n\n"},
+		{args: []string{"-f", "2,4", "--output-delimiter=, "}, want: "first second third\n1 2 3\nb, d\nno delimiter here\nleading tab\n\nx::y::z\none:two::three:\nabcdefghij\n\n"},
+		{args: []string{"-b", "1-3"}, want: "fir\n1 2\na\tb\nno \n\tle\ntra\nx::\none\nabc\n\n"},
+		{args: []string{"-b", "2,4-5,9-"}, want: "istcond third\n  3\n\t\tc\nodeiter here\nlad tab\nril tab\t\n:y:\nn:t:three:\nbdeij\n\n"},
+		{args: []string{"-b", "-2,8-"}, want: "fiecond third\n1 \na\t\nnomiter here\n\tlg tab\ntrg tab\t\nx:\non::three:\nabhij\n\n"},
+		{args: []string{"-b", "3-", "--complement"}, want: "fi\n1 \na\t\nno\n\tl\ntr\nx:\non\nab\n\n"},
+		{args: []string{"-b", "1-2,5-6", "--output-delimiter=:"}, want: "fi:t \n1 :3\na\t:c\t\nno:el\n\tl:di\ntr:li\nx::::\non:tw\nab:ef\n\n"},
+		{args: []string{"-b", "1-2,3-4", "--output-delimiter=:"}, want: "fi:rs\n1 :2 \na\t:b\t\nno: d\n\tl:ea\ntr:ai\nx:::y\non:e:\nab:cd\n\n"},
+		{args: []string{"-c", "1,3,5"}, want: "frt\n123\nabc\nn e\n\ted\ntal\nx::\noet\nace\n\n"},
+		{args: []string{"-c", "2-4", "--complement"}, want: "ft second third\n13\nac\td\nnelimiter here\n\tding tab\ntling tab\t\nx::z\notwo::three:\naefghij\n\n"},
+		{args: []string{"-c", "1-2,4-", "--complement", "--output-delimiter=_"}, want: "r\n2\nb\n \ne\na\n:\ne\nc\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			params, err := parseArgs(append(tt.args, testDataDir+"/conformance.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &bytes.Buffer{}
+			if err := cutFile(params.filenames[0], params, out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("doCut() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_doCut_extensions(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		data []string
+		want string
+	}{
+		{
+			name: "characters are runes",
+			args: []string{"-c", "2-3"},
+			data: []string{"привет", "日本語です"},
+			want: "ри\n本語\n",
+		},
+		{
+			name: "bytes split runes",
+			args: []string{"-b", "1-2"},
+			data: []string{"привет"},
+			want: "п\n",
+		},
+		{
+			name: "multi-character delimiter",
+			args: []string{"-d", "::", "-f", "2,3"},
+			data: []string{"a::b:c::d", "no"},
+			want: "b:c::d\nno\n",
+		},
+		{
+			name: "regexp delimiter keeps matched separators",
+			args: []string{"-regex", "-d", "[ \t]+", "-f", "1-2,4"},
+			data: []string{"a  b\tc d", "single"},
+			want: "a  b\td\nsingle\n",
+		},
+		{
+			name: "regexp delimiter with output delimiter",
+			args: []string{"-regex", "-d", ",\\s*", "-f", "2-", "--output-delimiter=;", "-s"},
+			data: []string{"x, y,z", "none"},
+			want: "y;z\n",
+		},
+		{
+			name: "GNU-style glued flags",
+			args: []string{"-d:", "-sf2"},
+			data: []string{"a:b", "none"},
+			want: "b\n",
+		},
+		{
+			name: "NUL-terminated lines",
+			args: []string{"-z", "-d", ",", "-f", "2"},
+			data: []string{"a,b\nc", "d,e"},
+			want: "b\nc\x00e\x00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := parseArgs(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &bytes.Buffer{}
+			if err := doCut(joinLines(tt.data, params.terminator()), params, out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("doCut() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseArgs_errors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-f", "1", "-b", "2"},
+		{"-b", "1", "-d", ","},
+		{"-c", "1", "-s"},
+		{"-f", "0"},
+		{"-regex", "-d", "(", "-f", "1"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%q) succeeded, want error", args)
+		}
+	}
+}
+
+func Test_cutFile_multipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	first, second := dir+"/first.txt", dir+"/second.txt"
+	if err := os.WriteFile(first, []byte("a:b:c\nd:e:f\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// последняя строка без перевода строки тоже обрабатывается
+	if err := os.WriteFile(second, []byte("g:h:i\nj:k:l"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := parseArgs([]string{"-d", ":", "-f", "1,3", first, dir + "/missing.txt", second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	var failed []string
+	for _, name := range params.filenames {
+		if err := cutFile(name, params, out); err != nil {
+			failed = append(failed, name)
+		}
+	}
+
+	if want := "a:c\nd:f\ng:i\nj:l\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if !reflect.DeepEqual(failed, []string{dir + "/missing.txt"}) {
+		t.Errorf("failed files = %v, want only the missing one", failed)
+	}
+	if params, _ := parseArgs([]string{"-f", "1"}); !reflect.DeepEqual(params.filenames, []string{"-"}) {
+		t.Errorf("filenames without arguments = %q, want stdin", params.filenames)
+	}
+}
+
+// benchInput - около 16 МБ строк по 8 полей
+func benchInput() string {
+	var b strings.Builder
+	for i := 0; b.Len() < 16<<20; i++ {
+		fmt.Fprintf(&b, "%d\tfield-%d\tполе\t%x\t%d\tvalue\t%d\tlast\n", i, i%97, i*31, i%7, i*7)
+	}
+	return b.String()
+}
+
+func benchmarkCut(b *testing.B, args ...string) {
+	input := benchInput()
+	params, err := parseArgs(args)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := doCut(strings.NewReader(input), params, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_doCut_fields(b *testing.B) {
+	benchmarkCut(b, "-f", "1,3-4,7-")
+}
+
+func Benchmark_doCut_firstField(b *testing.B) {
+	benchmarkCut(b, "-f", "1")
+}
+
+func Benchmark_doCut_regexp(b *testing.B) {
+	benchmarkCut(b, "-regex", "-d", "\t+", "-f", "2,5")
+}
+
+func Benchmark_doCut_chars(b *testing.B) {
+	benchmarkCut(b, "-c", "2-10,20-")
+}
+
+func Test_expandShortFlags(t *testing.T) {
+	flags := flag.NewFlagSet("cut", flag.ContinueOnError)
+	flags.String("f", "", "")
+	flags.String("d", "", "")
+	flags.Bool("s", false, "")
+	flags.Bool("regex", false, "")
+
+	// те же формы, что и в sort: слитые короткие флаги, длинные флаги с одним дефисом, -flag=value
+	got := expandShortFlags(flags, []string{"-d:", "-sf1,3", "-regex", "-f=2", "-f", "-2", "--output-delimiter=-", "-", "--", "-sf"})
+	want := []string{"-d", ":", "-s", "-f", "1,3", "-regex", "-f=2", "-f", "-2", "--output-delimiter=-", "-", "--", "-sf"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandShortFlags() = %q, want %q", got, want)
+	}
+}
+
+func Test_doCutCSV(t *testing.T) {
+	const people = "name,email,note\n" +
+		"\"Smith, J\",smith@example.com,\"multi\nline\"\n" +
+		"Brown,brown@example.com,\"say \"\"hi\"\"\"\n" +
+		"Short\n"
+
+	tests := []struct {
+		name    string
+		args    []string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "columns by name are reordered",
+			args:  []string{"--csv", "-f", "email,name"},
+			input: people,
+			want: "email,name\n" +
+				"smith@example.com,\"Smith, J\"\n" +
+				"brown@example.com,Brown\n" +
+				",Short\n",
+		},
+		{
+			name:  "numbers, ranges and names",
+			args:  []string{"--csv", "-f", "3,note,1-2"},
+			input: people,
+			want: "note,note,name,email\n" +
+				"\"multi\nline\",\"multi\nline\",\"Smith, J\",smith@example.com\n" +
+				"\"say \"\"hi\"\"\",\"say \"\"hi\"\"\",Brown,brown@example.com\n" +
+				",,Short,\n",
+		},
+		{
+			name:  "complement",
+			args:  []string{"--csv", "-f", "email", "--complement"},
+			input: people,
+			want: "name,note\n" +
+				"\"Smith, J\",\"multi\nline\"\n" +
+				"Brown,\"say \"\"hi\"\"\"\n" +
+				"Short,\n",
+		},
+		{
+			name:  "semicolons in, commas out",
+			args:  []string{"--csv", "-d", ";", "--output-delimiter=,", "-f", "b,a"},
+			input: "a;b\n1,5;x\n",
+			want:  "b,a\nx,\"1,5\"\n",
+		},
+		{
+			name:  "ranges are clamped to the header",
+			args:  []string{"--csv", "-f", "2-100"},
+			input: "a,b,c\n1,2,3\n",
+			want:  "b,c\n2,3\n",
+		},
+		{
+			name:  "open range with a short header",
+			args:  []string{"--csv", "-f", "2-"},
+			input: "a,b\n1,2,3\n",
+			want:  "b\n2\n",
+		},
+		{
+			name:  "numeric header names win over numbers",
+			args:  []string{"--csv", "-f", "2024,1,3"},
+			input: "region,2023,2024\nnorth,10,20\n",
+			want:  "2024,region,2024\n20,north,20\n",
+		},
+		{
+			name:    "unknown column",
+			args:    []string{"--csv", "-f", "phone"},
+			input:   people,
+			wantErr: true,
+		},
+		{
+			name:    "bad quoting",
+			args:    []string{"--csv", "-f", "1"},
+			input:   "a\n\"unterminated\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := parseArgs(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := &bytes.Buffer{}
+			err = doCutCSV(strings.NewReader(tt.input), params, out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("doCutCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && out.String() != tt.want {
+				t.Errorf("doCutCSV() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+
+	for _, args := range [][]string{
+		{"--csv", "-b", "1"},
+		{"--csv", "-f", "1", "-s"},
+		{"--csv", "-d", "::", "-f", "1"},
+		{"--csv", "-f", "a,,b"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parseArgs(%q) succeeded, want error", args)
+		}
+	}
+}
+
+func Test_cutFile_csvHeaderOnce(t *testing.T) {
+	dir := t.TempDir()
+	first, second := dir+"/first.csv", dir+"/second.csv"
+	if err := os.WriteFile(first, []byte("id,name\n1,a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// во втором файле колонки в другом порядке - имена ищутся по его заголовку
+	if err := os.WriteFile(second, []byte("name,id\nb,2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := parseArgs([]string{"--csv", "-f", "name,id", first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for i, name := range params.filenames {
+		params.skipHeader = i > 0
+		if err := cutFile(name, params, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "name,id\na,1\nb,2\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
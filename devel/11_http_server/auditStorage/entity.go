@@ -0,0 +1,140 @@
+package auditstorage
+
+import (
+	"calendar-server/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// AuditStorage - журнал изменений календаря. Записи только добавляются в конец файла
+// (по одному JSON-объекту на строку) и никогда не изменяются и не удаляются
+type AuditStorage struct {
+	file    *os.File
+	records []models.AuditRecord
+	lastID  int
+	rwm     sync.RWMutex
+}
+
+func New(filename string) (*AuditStorage, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("auditstorage New: %w", err)
+	}
+
+	records, err := readRecords(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("auditstorage New: %w", err)
+	}
+
+	var lastID int
+	for i := 0; i < len(records); i++ {
+		if lastID < records[i].ID {
+			lastID = records[i].ID
+		}
+	}
+
+	return &AuditStorage{
+		file:    file,
+		records: records,
+		lastID:  lastID,
+	}, nil
+}
+
+func readRecords(file *os.File) ([]models.AuditRecord, error) {
+	decoder := json.NewDecoder(file)
+
+	var records []models.AuditRecord
+	for {
+		var rec models.AuditRecord
+		if err := decoder.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return nil, fmt.Errorf("readRecords: %w", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func (as *AuditStorage) Close() error {
+	as.rwm.Lock()
+	defer as.rwm.Unlock()
+
+	return as.file.Close()
+}
+
+// Record присваивает записи идентификатор и сразу сохраняет её на диск,
+// чтобы журнал не зависел от корректного завершения сервера
+func (as *AuditStorage) Record(rec models.AuditRecord) (models.AuditRecord, error) {
+	as.rwm.Lock()
+	defer as.rwm.Unlock()
+
+	rec.ID = as.lastID + 1
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return models.AuditRecord{}, fmt.Errorf("Record: %w", err)
+	}
+	if _, err := as.file.Write(append(line, '\n')); err != nil {
+		return models.AuditRecord{}, fmt.Errorf("Record: %w", err)
+	}
+	if err := as.file.Sync(); err != nil {
+		return models.AuditRecord{}, fmt.Errorf("Record: %w", err)
+	}
+
+	as.lastID = rec.ID
+	as.records = append(as.records, rec)
+
+	return copyRecord(rec), nil
+}
+
+func (as *AuditStorage) Find(filter models.AuditFilter) ([]models.AuditRecord, error) {
+	as.rwm.RLock()
+	defer as.rwm.RUnlock()
+
+	found := []models.AuditRecord{}
+	for i := 0; i < len(as.records); i++ {
+		if matchFilter(as.records[i], filter) {
+			found = append(found, copyRecord(as.records[i]))
+		}
+	}
+
+	return found, nil
+}
+
+func matchFilter(rec models.AuditRecord, filter models.AuditFilter) bool {
+	if rec.Tenant != filter.Tenant || rec.TenantIncarnation != filter.TenantIncarnation {
+		return false
+	}
+	if filter.EventID != 0 && rec.EventID != filter.EventID {
+		return false
+	}
+	if filter.Actor != "" && rec.Actor != filter.Actor {
+		return false
+	}
+	if !filter.From.IsZero() && rec.Timestamp.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !rec.Timestamp.Before(filter.To) {
+		return false
+	}
+	return true
+}
+
+// копия нужна, чтобы вызывающий код не мог изменить сохранённую запись через указатели
+func copyRecord(rec models.AuditRecord) models.AuditRecord {
+	if rec.Before != nil {
+		before := *rec.Before
+		rec.Before = &before
+	}
+	if rec.After != nil {
+		after := *rec.After
+		rec.After = &after
+	}
+	rec.Changes = append([]models.AuditChange{}, rec.Changes...)
+	return rec
+}
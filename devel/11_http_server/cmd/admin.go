@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	auditstorage "calendar-server/auditStorage"
+	"calendar-server/config"
+	eventstorage "calendar-server/eventStorage"
+	"calendar-server/filedb"
+	"calendar-server/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Параметры административных команд, которые выполняются без запуска сервера
+type adminCommands struct {
+	backupTo    string
+	restoreFrom string
+	migrateTo   string
+}
+
+func (c adminCommands) isSet() bool {
+	return c.backupTo != "" || c.restoreFrom != "" || c.migrateTo != ""
+}
+
+// runAdminCommands выполняет команду. Резервное копирование и восстановление идут через
+// запущенный сервер (файл БД он перезаписывает только при остановке), а если сервер
+// не запущен - напрямую с файлом БД
+func runAdminCommands(c adminCommands, cfg config.Config) error {
+	if c.backupTo != "" || c.restoreFrom != "" {
+		err := runOnline(c, cfg)
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return err
+		}
+		log.Printf("server at %s is not running, using %s directly", cfg.Port, cfg.DbFilename)
+	}
+
+	db, err := filedb.New(cfg.DbFilename)
+	if err != nil {
+		return fmt.Errorf("NewFileDB: %w", err)
+	}
+	defer db.Close()
+
+	if c.migrateTo != "" {
+		dst, err := filedb.New(c.migrateTo)
+		if err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		defer dst.Close()
+
+		n, err := eventstorage.Migrate(db, dst)
+		if err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		log.Printf("migrated %d events to %s (schema version %d)", n, c.migrateTo, models.EventsSchemaVersion)
+		return nil
+	}
+
+	es, err := eventstorage.New(db)
+	if err != nil {
+		return err
+	}
+
+	if c.backupTo != "" {
+		data, err := json.Marshal(es.Snapshot())
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		if err := os.WriteFile(c.backupTo, data, 0666); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		log.Printf("backup written to %s", c.backupTo)
+		return nil
+	}
+
+	data, err := os.ReadFile(c.restoreFrom)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	var snapshot models.EventsSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	replaced, err := es.Restore(snapshot)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	audit, err := auditstorage.New(cfg.AuditFilename)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	defer audit.Close()
+	_, err = audit.Record(models.AuditRecord{
+		Action:    models.AuditRestore,
+		Actor:     "cli",
+		Timestamp: time.Now().UTC(),
+		Changes:   []models.AuditChange{{Field: "events", Old: strconv.Itoa(replaced), New: strconv.Itoa(len(snapshot.Events))}},
+	})
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	log.Printf("restored %d events from %s", len(snapshot.Events), c.restoreFrom)
+	return nil
+}
+
+// runOnline делает снимок или восстановление через /admin/backup и /admin/restore запущенного сервера.
+// Если сервер не запущен, возвращает ошибку с syscall.ECONNREFUSED
+func runOnline(c adminCommands, cfg config.Config) error {
+	host, port, err := net.SplitHostPort(cfg.Port)
+	if err != nil {
+		return fmt.Errorf("server address %q: %w", cfg.Port, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	baseURL := "http://" + net.JoinHostPort(host, port)
+
+	var request *http.Request
+	if c.backupTo != "" {
+		request, err = http.NewRequest(http.MethodGet, baseURL+"/admin/backup", nil)
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(c.restoreFrom); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		request, err = http.NewRequest(http.MethodPost, baseURL+"/admin/restore", bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Admin-Token", cfg.AdminToken)
+	request.Header.Set("X-User-ID", "cli")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", request.Method, request.URL.Path, response.Status, bytes.TrimSpace(body))
+	}
+
+	if c.backupTo != "" {
+		if err := os.WriteFile(c.backupTo, body, 0666); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		log.Printf("backup of the running server written to %s", c.backupTo)
+		return nil
+	}
+	log.Printf("restored %s on the running server: %s", c.restoreFrom, bytes.TrimSpace(body))
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"calendar-server/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runAdminCommands_backup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewTestConfig()
+	cfg.AdminToken = "secret"
+	cfg.DbFilename = filepath.Join(dir, "db.json")
+	cfg.AuditFilename = filepath.Join(dir, "audit.json")
+	if err := os.WriteFile(cfg.DbFilename, []byte(`{"version":2,"last_id":7,"events":[]}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Запущенный сервер: снимок берётся у него, а не из файла БД
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/backup" || r.Header.Get("X-Admin-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"version":2,"last_id":9,"events":[{"ID":9,"UserID":1,"Name":"live","Date":"2024-01-01"}]}`))
+	}))
+	cfg.Port = strings.TrimPrefix(live.URL, "http://")
+
+	backup := filepath.Join(dir, "backup.json")
+	if err := runAdminCommands(adminCommands{backupTo: backup}, *cfg); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(backup); !strings.Contains(string(data), `"live"`) {
+		t.Errorf("backup = %s, want the running server snapshot", data)
+	}
+
+	// Сервер не запущен: снимок делается из файла БД
+	live.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Port = listener.Addr().String()
+	listener.Close()
+
+	if err := runAdminCommands(adminCommands{backupTo: backup}, *cfg); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(backup); !strings.Contains(string(data), `"last_id":7`) {
+		t.Errorf("backup = %s, want the db file snapshot", data)
+	}
+}
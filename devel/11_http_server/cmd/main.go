@@ -1,20 +1,41 @@
 package main
 
 import (
+	auditstorage "calendar-server/auditStorage"
 	"calendar-server/config"
 	eventstorage "calendar-server/eventStorage"
 	"calendar-server/filedb"
 	"calendar-server/server"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 func main() {
+	var commands adminCommands
+	flag.StringVar(&commands.backupTo, "backup", "", "Write a backup of the db to file and exit")
+	flag.StringVar(&commands.restoreFrom, "restore", "", "Restore the db from a backup file and exit")
+	flag.StringVar(&commands.migrateTo, "migrate-to", "", "Copy events to another file db in the current schema version and exit")
 	cfg := config.NewDefaultConfig()
+	flag.StringVar(&cfg.TenantsDir, "tenants-dir", cfg.TenantsDir, "Directory with per-tenant storage; enables multi-tenant mode")
+	flag.Parse()
+
+	cfg.AdminToken = os.Getenv("CALENDAR_ADMIN_TOKEN")
+
+	if cfg.TenantsDir != "" && !commands.isSet() {
+		runMultiTenant(*cfg)
+		return
+	}
+
+	if commands.isSet() {
+		if err := runAdminCommands(commands, *cfg); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
 	db, err := filedb.New(cfg.DbFilename)
 	if err != nil {
@@ -26,16 +47,49 @@ func main() {
 		log.Fatalf("eventstorage: %s", err.Error())
 	}
 
-	server := server.New(*cfg, es)
+	audit, err := auditstorage.New(cfg.AuditFilename)
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+
+	server := server.New(*cfg, es, audit)
+
+	waitForShutdown(server, cfg.Port)
+
+	// Запускаем деструкторы для наших сущностей, чтобы они корректно завершили работу.
+
+	// Закрываем слой хранения событий, чтобы изменения записались в файл-БД
+	if err := es.Close(); err != nil {
+		log.Printf("Error while ES close; %s", err.Error())
+	} else {
+		log.Println("ES closed!")
+	}
+
+	// Коррктено отдаём ресурс - закрываем файловый дескриптор
+	if err := db.Close(); err != nil {
+		log.Printf("Error while fileDb closing; %s", err.Error())
+	} else {
+		log.Println("DB closed!")
+	}
+
+	if err := audit.Close(); err != nil {
+		log.Printf("Error while audit closing; %s", err.Error())
+	} else {
+		log.Println("Audit closed!")
+	}
+}
 
+// waitForShutdown запускает сервер и блокируется до ошибки сервера или сигнала от ОС,
+// после чего дожидается завершения текущих запросов
+func waitForShutdown(server *server.Server, port string) {
 	// Создаем каналы для перехвата сигнала от ОС и синхронизации закрытия сервера.
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
 	serverErrors := make(chan error, 1)
 
 	go func() {
-		log.Printf("server: started at address %v", cfg.Port)
-		serverErrors <- http.ListenAndServe(cfg.Port, nil)
+		log.Printf("server: started at address %v", port)
+		serverErrors <- server.ListenAndServe()
 	}()
 
 	exitCh := make(chan struct{})
@@ -61,26 +115,10 @@ func main() {
 
 	<-exitCh
 
-	// Запускаем деструкторы для наших сущностей, чтобы они корректно завершили работу.
-
 	// Сначала ожидаем закрытия сервера, чтобы текущие запросы окончили работу. Новые не принимаются
 	if err := server.Shutdown(); err != nil {
 		log.Printf("Error while closing server; %s", err.Error())
 	} else {
 		log.Println("Server closed!")
 	}
-
-	// Закрываем слой хранения событий, чтобы изменения записались в файл-БД
-	if err := es.Close(); err != nil {
-		log.Printf("Error while ES close; %s", err.Error())
-	} else {
-		log.Println("ES closed!")
-	}
-
-	// Коррктено отдаём ресурс - закрываем файловый дескриптор
-	if err := db.Close(); err != nil {
-		log.Printf("Error while fileDb closing; %s", err.Error())
-	} else {
-		log.Println("DB closed!")
-	}
 }
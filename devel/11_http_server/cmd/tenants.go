@@ -0,0 +1,51 @@
+package main
+
+import (
+	auditstorage "calendar-server/auditStorage"
+	"calendar-server/config"
+	"calendar-server/server"
+	tenantstorage "calendar-server/tenantStorage"
+	"log"
+)
+
+// tenantService адаптирует TenantStorage к интерфейсу server.TenantService
+type tenantService struct {
+	*tenantstorage.TenantStorage
+}
+
+func (ts tenantService) Events(tenantID string) (server.EventService, string, func(), error) {
+	events, incarnation, release, err := ts.TenantStorage.Events(tenantID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return events, incarnation, release, nil
+}
+
+func runMultiTenant(cfg config.Config) {
+	tenants, err := tenantstorage.New(cfg.TenantsDir, cfg.TenantMaxEvents)
+	if err != nil {
+		log.Fatalf("tenantstorage: %s", err.Error())
+	}
+
+	audit, err := auditstorage.New(cfg.AuditFilename)
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+
+	calendar := server.NewMultiTenant(cfg, tenantService{tenants}, audit)
+
+	waitForShutdown(calendar, cfg.Port)
+
+	// Сохраняем события всех арендаторов и закрываем их файлы
+	if err := tenants.Close(); err != nil {
+		log.Printf("Error while tenants closing; %s", err.Error())
+	} else {
+		log.Println("Tenants closed!")
+	}
+
+	if err := audit.Close(); err != nil {
+		log.Printf("Error while audit closing; %s", err.Error())
+	} else {
+		log.Println("Audit closed!")
+	}
+}
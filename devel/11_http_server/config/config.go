@@ -1,14 +1,50 @@
 package config
 
 type Config struct {
-	DbFilename string
-	Port       string
+	DbFilename    string
+	AuditFilename string
+	Port          string
+
+	// Ограничение частоты запросов на одного клиента: RateLimit запросов в секунду
+	// с допустимым всплеском до RateBurst. RateLimit <= 0 отключает ограничение
+	RateLimit float64
+	RateBurst int
+	// Максимальный размер тела запроса в байтах
+	MaxBodyBytes int64
+
+	// Токен для административных эндпоинтов (заголовок X-Admin-Token). Пустой токен отключает их
+	AdminToken string
+	// Максимальный размер резервной копии, принимаемой при восстановлении
+	MaxSnapshotBytes int64
+
+	// Каталог с данными арендаторов. Пустое значение - режим с одним общим хранилищем
+	TenantsDir string
+	// Квота на количество событий для арендатора, если при создании она не указана
+	TenantMaxEvents int
 }
 
 func NewDefaultConfig() *Config {
-	return &Config{DbFilename: "db.txt", Port: ":8080"}
+	return &Config{
+		DbFilename:       "db.txt",
+		AuditFilename:    "audit.txt",
+		Port:             ":8080",
+		RateLimit:        10,
+		RateBurst:        20,
+		MaxBodyBytes:     1 << 20,
+		MaxSnapshotBytes: 256 << 20,
+		TenantMaxEvents:  10000,
+	}
 }
 
 func NewTestConfig() *Config {
-	return &Config{DbFilename: "test_db.txt", Port: ":8081"}
+	return &Config{
+		DbFilename:       "test_db.txt",
+		AuditFilename:    "test_audit.txt",
+		Port:             ":8081",
+		RateLimit:        10,
+		RateBurst:        20,
+		MaxBodyBytes:     1 << 20,
+		MaxSnapshotBytes: 256 << 20,
+		TenantMaxEvents:  10000,
+	}
 }
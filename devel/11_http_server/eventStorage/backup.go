@@ -0,0 +1,113 @@
+package eventstorage
+
+import (
+	"calendar-server/models"
+	"fmt"
+	"time"
+)
+
+// Snapshot возвращает согласованную копию всех событий. Берётся только блокировка на чтение,
+// поэтому резервное копирование не останавливает обработку запросов на чтение
+func (es *EventStorage) Snapshot() models.EventsSnapshot {
+	es.rwm.RLock()
+	defer es.rwm.RUnlock()
+
+	events := make([]models.EventData, len(es.events))
+	copy(events, es.events)
+
+	return models.EventsSnapshot{
+		Version:   models.EventsSchemaVersion,
+		CreatedAt: time.Now().UTC(),
+		LastID:    es.lastID,
+		Events:    events,
+	}
+}
+
+// Restore полностью заменяет события данными из снимка и сразу сохраняет их в БД.
+// Возвращает количество замещённых событий. Снимок больше квоты хранилища не принимается
+func (es *EventStorage) Restore(snapshot models.EventsSnapshot) (int, error) {
+	events, err := models.MigrateEvents(snapshot.Version, snapshot.Events)
+	if err != nil {
+		return 0, fmt.Errorf("Restore: %w", err)
+	}
+
+	lastID, err := validateEvents(events)
+	if err != nil {
+		return 0, fmt.Errorf("Restore: %w", err)
+	}
+	// Идентификаторы не должны переиспользоваться, даже если последние события были удалены
+	if lastID < snapshot.LastID {
+		lastID = snapshot.LastID
+	}
+
+	restored := make([]models.EventData, len(events))
+	copy(restored, events)
+
+	es.rwm.Lock()
+	defer es.rwm.Unlock()
+
+	if es.maxEvents > 0 && len(restored) > es.maxEvents {
+		return 0, fmt.Errorf("Restore: %d events: %w", len(restored), models.ErrQuotaExceeded)
+	}
+	// ID, выданные после снимка, тоже не должны вернуться
+	if lastID < es.lastID {
+		lastID = es.lastID
+	}
+
+	if err := es.save(restored, lastID); err != nil {
+		return 0, fmt.Errorf("Restore: %w", err)
+	}
+	replaced := len(es.events)
+	es.events = restored
+	es.lastID = lastID
+
+	return replaced, nil
+}
+
+// Migrate переносит все события из одной реализации DB в другую
+func Migrate(src, dst DB) (int, error) {
+	var snapshot models.EventsSnapshot
+	var err error
+	if sdb, ok := src.(SnapshotDB); ok {
+		snapshot, err = sdb.GetSnapshot()
+	} else {
+		snapshot.Events, err = src.GetEvents()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Migrate: %w", err)
+	}
+	events := snapshot.Events
+	if _, err := validateEvents(events); err != nil {
+		return 0, fmt.Errorf("Migrate: %w", err)
+	}
+
+	if sdb, ok := dst.(SnapshotDB); ok {
+		snapshot.Version = models.EventsSchemaVersion
+		err = sdb.SaveSnapshot(snapshot)
+	} else {
+		err = dst.SaveEvents(events)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Migrate: %w", err)
+	}
+	return len(events), nil
+}
+
+// validateEvents проверяет уникальность идентификаторов и возвращает максимальный из них
+func validateEvents(events []models.EventData) (int, error) {
+	var lastID int
+	ids := make(map[int]struct{}, len(events))
+	for _, e := range events {
+		if e.ID <= 0 {
+			return 0, fmt.Errorf("event has bad id: %d", e.ID)
+		}
+		if _, ok := ids[e.ID]; ok {
+			return 0, fmt.Errorf("duplicate event id: %d", e.ID)
+		}
+		ids[e.ID] = struct{}{}
+		if lastID < e.ID {
+			lastID = e.ID
+		}
+	}
+	return lastID, nil
+}
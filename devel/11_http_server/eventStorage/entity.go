@@ -13,21 +13,39 @@ type DB interface {
 	SaveEvents([]models.EventData) error
 }
 
+// SnapshotDB - DB, которая хранит вместе с событиями последний выданный ID. Без неё после
+// перезапуска lastID восстанавливается как максимальный ID, и ID удалённых последними событий
+// выдаются повторно
+type SnapshotDB interface {
+	GetSnapshot() (models.EventsSnapshot, error)
+	SaveSnapshot(models.EventsSnapshot) error
+}
+
 type EventStorage struct {
 	db     DB
 	events []models.EventData
 	lastID int
-	rwm    sync.RWMutex
+	// Максимальное количество событий, 0 - без ограничения
+	maxEvents int
+	rwm       sync.RWMutex
 }
 
 func New(db DB) (*EventStorage, error) {
-	oldEvents, err := db.GetEvents()
+	var oldEvents []models.EventData
+	var lastID int
+	var err error
+	if sdb, ok := db.(SnapshotDB); ok {
+		var snapshot models.EventsSnapshot
+		snapshot, err = sdb.GetSnapshot()
+		oldEvents, lastID = snapshot.Events, snapshot.LastID
+	} else {
+		oldEvents, err = db.GetEvents()
+	}
 	if err != nil {
 		log.Fatalf("File db error open %v", err)
 		return nil, fmt.Errorf("New: %w", err)
 	}
 
-	var lastID int
 	for i := 0; i < len(oldEvents); i++ {
 		if lastID < oldEvents[i].ID {
 			lastID = oldEvents[i].ID
@@ -42,14 +60,31 @@ func New(db DB) (*EventStorage, error) {
 	}, nil
 }
 
+func (es *EventStorage) SetMaxEvents(maxEvents int) {
+	es.rwm.Lock()
+	defer es.rwm.Unlock()
+
+	es.maxEvents = maxEvents
+}
+
 func (es *EventStorage) Close() error {
-	return es.db.SaveEvents(es.events)
+	es.rwm.RLock()
+	defer es.rwm.RUnlock()
+
+	return es.save(es.events, es.lastID)
+}
+
+// save записывает события в БД, а если она это поддерживает - и lastID
+func (es *EventStorage) save(events []models.EventData, lastID int) error {
+	if sdb, ok := es.db.(SnapshotDB); ok {
+		return sdb.SaveSnapshot(models.EventsSnapshot{Version: models.EventsSchemaVersion, LastID: lastID, Events: events})
+	}
+	return es.db.SaveEvents(events)
 }
 
+// getNewID выдаёт следующий после lastID номер: lastID уже занят (после New это максимальный ID)
 func (es *EventStorage) getNewID() int {
-	defer func() {
-		es.lastID++
-	}()
+	es.lastID++
 	return es.lastID
 }
 
@@ -65,35 +100,42 @@ func (es *EventStorage) GetEvent(ID int) (models.EventData, error) {
 	return es.events[index], nil
 }
 
-func (es *EventStorage) AddEvent(data models.NewEventData) (int, error) {
+// AddEvent возвращает сохранённое событие: журнал берёт его отсюда, а не отдельным GetEvent,
+// который мог бы увидеть уже изменённое или удалённое событие
+func (es *EventStorage) AddEvent(data models.NewEventData) (models.EventData, error) {
 	es.rwm.Lock()
+	defer es.rwm.Unlock()
+
+	if es.maxEvents > 0 && len(es.events) >= es.maxEvents {
+		return models.EventData{}, fmt.Errorf("AddEvent: %w", models.ErrQuotaExceeded)
+	}
 
-	newID := es.getNewID()
 	event := models.EventData{
-		ID:     newID,
+		ID:     es.getNewID(),
 		UserID: data.UserID,
 		Name:   data.Name,
 		Date:   data.Date,
 	}
 	es.addEvent(event)
 
-	es.rwm.Unlock()
-
-	return newID, nil
+	return event, nil
 }
 
 func (es *EventStorage) addEvent(event models.EventData) {
 	es.events = append(es.events, event)
 }
 
-func (es *EventStorage) UpdateEvent(data models.UpdateEventData) (models.EventData, error) {
+// UpdateEvent возвращает событие до и после изменения. Оба значения берутся под одной блокировкой,
+// поэтому при параллельных изменениях они описывают соседние версии одного события
+func (es *EventStorage) UpdateEvent(data models.UpdateEventData) (models.EventData, models.EventData, error) {
 	es.rwm.Lock()
 	defer es.rwm.Unlock()
 
 	index, err := es.findIndexByID(data.ID)
 	if err != nil {
-		return models.EventData{}, fmt.Errorf("UpdateEvent: %w", err)
+		return models.EventData{}, models.EventData{}, fmt.Errorf("UpdateEvent: %w", err)
 	}
+	before := es.events[index]
 
 	if data.Name != nil {
 		es.events[index].Name = *data.Name
@@ -105,7 +147,7 @@ func (es *EventStorage) UpdateEvent(data models.UpdateEventData) (models.EventDa
 		es.events[index].Date = *data.Date
 	}
 
-	return es.events[index], nil
+	return before, es.events[index], nil
 }
 
 func (es *EventStorage) DeleteEvent(ID int) (models.EventData, error) {
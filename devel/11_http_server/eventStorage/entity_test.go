@@ -0,0 +1,139 @@
+package eventstorage
+
+import (
+	"calendar-server/filedb"
+	"calendar-server/models"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type memoryDB struct {
+	events []models.EventData
+}
+
+func (db *memoryDB) GetEvents() ([]models.EventData, error) {
+	return db.events, nil
+}
+
+func (db *memoryDB) SaveEvents(events []models.EventData) error {
+	db.events = events
+	return nil
+}
+
+// После перезапуска новое событие не должно получить ID уже существующего
+func TestAddEvent_noDuplicateIDAfterRestart(t *testing.T) {
+	db := &memoryDB{events: []models.EventData{{ID: 1, UserID: 1, Name: "old", Date: "2024-01-01"}}}
+	es, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := es.AddEvent(models.NewEventData{UserID: 1, Name: "new", Date: "2024-01-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 1 {
+		t.Fatalf("AddEvent() reused ID %d of an existing event", created.ID)
+	}
+	if stored, err := es.GetEvent(created.ID); err != nil || stored != created {
+		t.Errorf("GetEvent(%d) = %+v, %v, want %+v", created.ID, stored, err, created)
+	}
+	if _, err := es.GetEvent(1); err != nil {
+		t.Errorf("GetEvent(1) error = %v", err)
+	}
+
+	seen := make(map[int]bool)
+	for _, event := range es.events {
+		if seen[event.ID] {
+			t.Errorf("duplicate event ID %d", event.ID)
+		}
+		seen[event.ID] = true
+	}
+}
+
+func TestUpdateEvent_returnsPreviousVersion(t *testing.T) {
+	es, err := New(&memoryDB{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := es.AddEvent(models.NewEventData{UserID: 1, Name: "v0", Date: "2024-01-01"})
+	id := created.ID
+
+	// каждое изменение должно видеть как предыдущую версию результат другого изменения
+	const updates = 50
+	var wg sync.WaitGroup
+	befores := make(chan string, updates)
+	for i := 1; i <= updates; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			before, after, err := es.UpdateEvent(models.UpdateEventData{ID: id, Name: &name})
+			if err != nil || after.Name != name {
+				t.Errorf("UpdateEvent() = %+v, %v", after, err)
+			}
+			befores <- before.Name
+		}(fmt.Sprintf("v%d", i))
+	}
+	wg.Wait()
+	close(befores)
+
+	seen := make(map[string]bool)
+	for name := range befores {
+		if seen[name] {
+			t.Errorf("version %q was reported as previous by two updates", name)
+		}
+		seen[name] = true
+	}
+	if !seen["v0"] {
+		t.Errorf("no update reported the initial version as previous")
+	}
+}
+
+// ID удалённого события не выдаётся повторно и после перезапуска с файловой БД
+func TestLastID_persistedInFileDB(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "db.json")
+	open := func() (*filedb.FileDB, *EventStorage) {
+		db, err := filedb.New(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		es, err := New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, es
+	}
+
+	db, es := open()
+	es.AddEvent(models.NewEventData{UserID: 1, Name: "first", Date: "2024-01-01"})
+	second, _ := es.AddEvent(models.NewEventData{UserID: 1, Name: "second", Date: "2024-01-02"})
+	last := second.ID
+	if _, err := es.DeleteEvent(last); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, es = open()
+	defer db.Close()
+	third, err := es.AddEvent(models.NewEventData{UserID: 1, Name: "third", Date: "2024-01-03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := third.ID
+	if id <= last {
+		t.Errorf("AddEvent() after restart = %d, want an ID greater than deleted %d", id, last)
+	}
+
+	// Восстановление из старого снимка тоже не возвращает выданные ID
+	if _, err := es.Restore(models.EventsSnapshot{Version: models.EventsSchemaVersion}); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := es.AddEvent(models.NewEventData{UserID: 1, Name: "fourth", Date: "2024-01-04"}); again.ID <= id {
+		t.Errorf("AddEvent() after Restore = %d, want an ID greater than %d", again.ID, id)
+	}
+}
@@ -1,6 +1,7 @@
 package filedb
 
 import (
+	"bytes"
 	"calendar-server/models"
 	"encoding/json"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 )
 
 type FileDB struct {
@@ -37,34 +39,62 @@ func initFile(filename string) (*os.File, error) {
 }
 
 func (fdb *FileDB) GetEvents() ([]models.EventData, error) {
-	return getEvents(fdb.file)
+	snapshot, err := getSnapshot(fdb.file)
+	return snapshot.Events, err
 }
 
-func getEvents(file *os.File) ([]models.EventData, error) {
-	decoder := json.NewDecoder(file)
-	if _, err := decoder.Token(); err != nil {
+// GetSnapshot возвращает события вместе с последним выданным ID
+func (fdb *FileDB) GetSnapshot() (models.EventsSnapshot, error) {
+	return getSnapshot(fdb.file)
+}
+
+// getSnapshot читает файл в любой поддерживаемой версии схемы и приводит события к текущей
+func getSnapshot(file *os.File) (models.EventsSnapshot, error) {
+	empty := models.EventsSnapshot{Version: models.EventsSchemaVersion, Events: []models.EventData{}}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return empty, fmt.Errorf("getEvents seek: %w", err)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
 		if errors.Is(err, io.EOF) {
 			log.Printf("file %s is empty", file.Name())
-			return []models.EventData{}, nil
+			return empty, nil
 		}
-		return []models.EventData{}, fmt.Errorf("getEvents decoder: %w", err)
+		return empty, fmt.Errorf("getEvents decoder: %w", err)
 	}
 
-	var events []models.EventData
-	for decoder.More() {
-		var e models.EventData
-		if err := decoder.Decode(&e); err != nil {
-			return []models.EventData{}, fmt.Errorf("getEvents unmarhsal: %w", err)
+	var snapshot models.EventsSnapshot
+	if bytes.HasPrefix(raw, []byte("[")) {
+		// Версия 1: массив событий без обёртки
+		snapshot.Version = 1
+		if err := json.Unmarshal(raw, &snapshot.Events); err != nil {
+			return empty, fmt.Errorf("getEvents unmarhsal: %w", err)
 		}
-		events = append(events, e)
+	} else if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return empty, fmt.Errorf("getEvents unmarhsal: %w", err)
+	}
+
+	events, err := models.MigrateEvents(snapshot.Version, snapshot.Events)
+	if err != nil {
+		return empty, fmt.Errorf("getEvents: %w", err)
 	}
 
 	fmt.Printf("events: %+v\n", events)
 
-	return events, nil
+	snapshot.Version = models.EventsSchemaVersion
+	snapshot.Events = events
+	return snapshot, nil
 }
 
+// SaveEvents всегда записывает файл в текущей версии схемы
 func (db *FileDB) SaveEvents(data []models.EventData) error {
+	return db.SaveSnapshot(models.EventsSnapshot{Events: data})
+}
+
+// SaveSnapshot записывает события и последний выданный ID в текущей версии схемы
+func (db *FileDB) SaveSnapshot(snapshot models.EventsSnapshot) error {
+	data := snapshot.Events
 	if err := db.file.Truncate(0); err != nil {
 		return err
 	}
@@ -72,10 +102,19 @@ func (db *FileDB) SaveEvents(data []models.EventData) error {
 		return err
 	}
 
+	if data == nil {
+		data = []models.EventData{}
+	}
+
 	encoder := json.NewEncoder(db.file)
 	fmt.Println("Write to file db!!", db.file)
 
-	return encoder.Encode(data)
+	return encoder.Encode(models.EventsSnapshot{
+		Version:   models.EventsSchemaVersion,
+		CreatedAt: time.Now().UTC(),
+		LastID:    snapshot.LastID,
+		Events:    data,
+	})
 }
 
 func (db *FileDB) Close() error {
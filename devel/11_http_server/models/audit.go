@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+	// Замена всех событий из резервной копии: EventID = 0, в Changes - количество событий
+	AuditRestore AuditAction = "restore"
+)
+
+// Запись журнала аудита. После сохранения не изменяется
+type AuditRecord struct {
+	ID         int           `json:"id"`
+	Tenant     string        `json:"tenant,omitempty"`
+	Action     AuditAction   `json:"action"`
+	EventID    int           `json:"event_id"`
+	Actor      string        `json:"actor"`
+	Timestamp  time.Time     `json:"timestamp"`
+	RequestID  string        `json:"request_id"`
+	RemoteAddr string        `json:"remote_addr"`
+	Before     *EventData    `json:"before,omitempty"`
+	After      *EventData    `json:"after,omitempty"`
+	Changes    []AuditChange `json:"changes"`
+	// ClaimedActor - пользователь из заголовка X-User-ID. Клиент задаёт его сам, и он не проверяется
+	ClaimedActor string `json:"claimed_actor,omitempty"`
+	// TenantIncarnation отличает арендатора от удалённого ранее арендатора с тем же ID
+	TenantIncarnation string `json:"tenant_incarnation,omitempty"`
+}
+
+type AuditChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Нулевые значения полей фильтра означают отсутствие ограничения, кроме Tenant и TenantIncarnation:
+// записи разных арендаторов, в том числе удалённого и созданного заново с тем же ID, никогда не смешиваются
+type AuditFilter struct {
+	Tenant            string
+	TenantIncarnation string
+	EventID           int
+	Actor             string
+	From              time.Time
+	To                time.Time
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Текущая версия схемы хранения событий.
+//
+//	1 - файл содержит JSON-массив EventData без версии
+//	2 - файл содержит объект {"version": 2, "events": [...]}
+const EventsSchemaVersion = 2
+
+// Сохранённое состояние хранилища событий: формат файла БД и резервной копии
+type EventsSnapshot struct {
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"created_at"`
+	LastID    int         `json:"last_id,omitempty"`
+	Events    []EventData `json:"events"`
+}
+
+// Миграции по версиям: функция с ключом N переводит данные из версии N в N+1
+var eventsMigrations = map[int]func([]EventData) []EventData{
+	// версия 2 изменила только обёртку файла, сами события не меняются
+	1: func(events []EventData) []EventData { return events },
+}
+
+// MigrateEvents приводит события, сохранённые в версии схемы version, к текущей версии
+func MigrateEvents(version int, events []EventData) ([]EventData, error) {
+	if version < 1 || version > EventsSchemaVersion {
+		return nil, fmt.Errorf("MigrateEvents: unsupported schema version %d (current %d)", version, EventsSchemaVersion)
+	}
+	for v := version; v < EventsSchemaVersion; v++ {
+		migrate, ok := eventsMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("MigrateEvents: no migration from version %d", v)
+		}
+		events = migrate(events)
+	}
+	return events, nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("event quota exceeded")
+
+// Теги для хранения в реестре арендаторов. Сам токен не хранится, только его хеш
+type TenantData struct {
+	ID        string    `json:"id"`
+	MaxEvents int       `json:"max_events"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	// Incarnation - случайный идентификатор, новый при каждом создании арендатора.
+	// У арендаторов из реестра без этого поля он пустой
+	Incarnation string `json:"incarnation,omitempty"`
+}
+
+type NewTenantData struct {
+	ID        string
+	MaxEvents int
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"calendar-server/models"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// BackupService - необязательные возможности хранилища событий для резервного копирования.
+// Эндпоинты /admin/backup и /admin/restore работают, только если EventService арендатора их реализует
+type BackupService interface {
+	Snapshot() models.EventsSnapshot
+	// Restore возвращает количество замещённых событий
+	Restore(snapshot models.EventsSnapshot) (int, error)
+}
+
+// adminMiddleware пропускает только запросы с верным токеном администратора.
+// Если токен не задан в конфигурации, административные эндпоинты недоступны
+func (s *Server) adminMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := r.Header.Get(adminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			sendError(w, http.StatusForbidden, "admin token is bad")
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), adminKey, true)))
+	}
+}
+
+// isAdmin сообщает, что запрос прошёл adminMiddleware
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminKey).(bool)
+	return admin
+}
+
+// getBackup отдаёт снимок событий в формате файла БД, чтобы его можно было сразу передать в /admin/restore
+func (s *Server) getBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	backup, ok := s.eventService(r).(BackupService)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	snapshot := backup.Snapshot()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="calendar-backup.json"`)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	backup, ok := s.eventService(r).(BackupService)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var snapshot models.EventsSnapshot
+	if err := decodeJSONBody(w, r, &snapshot, s.maxSnapshotBytes); err != nil {
+		sendDecodeError(w, err)
+		return
+	}
+
+	replaced, err := backup.Restore(snapshot)
+	if errors.Is(err, models.ErrQuotaExceeded) {
+		sendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeRestoreAudit(r, replaced, len(snapshot.Events))
+
+	sendResponse(w, http.StatusOK, struct {
+		Events int `json:"events"`
+	}{Events: len(snapshot.Events)})
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"calendar-server/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Заголовок с именем пользователя. Его задаёт сам клиент, поэтому в журнал он попадает
+// только как ClaimedActor и автором изменения не считается
+const actorHeader = "X-User-ID"
+
+const (
+	anonymousActor = "anonymous"
+	adminActor     = "admin"
+	// Арендатор, прошедший проверку токена, записывается как tenant:<id>
+	tenantActorPrefix = "tenant:"
+)
+
+type AuditChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type AuditRecord struct {
+	ID         int           `json:"id"`
+	Tenant     string        `json:"tenant,omitempty"`
+	Action     string        `json:"action"`
+	EventID    int           `json:"event_id"`
+	Actor      string        `json:"actor"`
+	Timestamp  time.Time     `json:"timestamp"`
+	RequestID  string        `json:"request_id"`
+	RemoteAddr string        `json:"remote_addr"`
+	Before     *Event        `json:"before,omitempty"`
+	After      *Event        `json:"after,omitempty"`
+	Changes    []AuditChange `json:"changes"`
+
+	ClaimedActor string `json:"claimed_actor,omitempty"`
+}
+
+func convertAuditRecord(rec models.AuditRecord) AuditRecord {
+	res := AuditRecord{
+		ID:         rec.ID,
+		Tenant:     rec.Tenant,
+		Action:     string(rec.Action),
+		EventID:    rec.EventID,
+		Actor:      rec.Actor,
+		Timestamp:  rec.Timestamp,
+		RequestID:  rec.RequestID,
+		RemoteAddr: rec.RemoteAddr,
+		Changes:    make([]AuditChange, 0, len(rec.Changes)),
+
+		ClaimedActor: rec.ClaimedActor,
+	}
+	if rec.Before != nil {
+		before := convertEvent(*rec.Before)
+		res.Before = &before
+	}
+	if rec.After != nil {
+		after := convertEvent(*rec.After)
+		res.After = &after
+	}
+	for _, c := range rec.Changes {
+		res.Changes = append(res.Changes, AuditChange(c))
+	}
+	return res
+}
+
+func convertAuditRecords(recs []models.AuditRecord) []AuditRecord {
+	res := make([]AuditRecord, 0, len(recs))
+	for _, rec := range recs {
+		res = append(res, convertAuditRecord(rec))
+	}
+	return res
+}
+
+// actorFromRequest определяет автора изменения по проверенным данным запроса: токену администратора
+// или токену арендатора. Без токена X-Tenant-ID принимается только от администратора, так что
+// арендатор в контексте запроса не администратора всегда подтверждён токеном
+func actorFromRequest(r *http.Request) string {
+	if isAdmin(r) {
+		return adminActor
+	}
+	if tenantID := tenantFromContext(r.Context()); tenantID != "" {
+		return tenantActorPrefix + tenantID
+	}
+	return anonymousActor
+}
+
+// writeAudit сохраняет запись об изменении события. Само изменение к этому моменту уже применено,
+// поэтому ошибка журнала не отменяет запрос, а только логируется
+func (s *Server) writeAudit(r *http.Request, action models.AuditAction, eventID int, before, after *models.EventData) {
+	if s.audit == nil {
+		return
+	}
+
+	rec := newAuditRecord(r, action, eventID)
+	rec.Before = before
+	rec.After = after
+	rec.Changes = diffEvents(before, after)
+	s.recordAudit(rec)
+}
+
+// writeRestoreAudit сохраняет запись о замене всех событий из резервной копии
+func (s *Server) writeRestoreAudit(r *http.Request, replaced, restored int) {
+	if s.audit == nil {
+		return
+	}
+
+	rec := newAuditRecord(r, models.AuditRestore, 0)
+	rec.Changes = []models.AuditChange{{Field: "events", Old: strconv.Itoa(replaced), New: strconv.Itoa(restored)}}
+	s.recordAudit(rec)
+}
+
+func newAuditRecord(r *http.Request, action models.AuditAction, eventID int) models.AuditRecord {
+	return models.AuditRecord{
+		Tenant:     tenantFromContext(r.Context()),
+		Action:     action,
+		EventID:    eventID,
+		Actor:      actorFromRequest(r),
+		Timestamp:  time.Now().UTC(),
+		RequestID:  requestIDFromContext(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+
+		ClaimedActor:      r.Header.Get(actorHeader),
+		TenantIncarnation: incarnationFromContext(r.Context()),
+	}
+}
+
+func (s *Server) recordAudit(rec models.AuditRecord) {
+	if _, err := s.audit.Record(rec); err != nil {
+		log.Printf("audit: %s %d: %s", rec.Action, rec.EventID, err.Error())
+	}
+}
+
+// diffEvents возвращает список изменённых полей события. При создании before == nil, при удалении after == nil
+func diffEvents(before, after *models.EventData) []models.AuditChange {
+	oldFields, newFields := eventFields(before), eventFields(after)
+
+	changes := []models.AuditChange{}
+	for i, name := range []string{"id", "user_id", "name", "date"} {
+		if oldFields[i] != newFields[i] {
+			changes = append(changes, models.AuditChange{Field: name, Old: oldFields[i], New: newFields[i]})
+		}
+	}
+	return changes
+}
+
+func eventFields(e *models.EventData) [4]string {
+	if e == nil {
+		return [4]string{}
+	}
+	return [4]string{strconv.Itoa(e.ID), strconv.Itoa(e.UserID), e.Name, e.Date}
+}
+
+func (s *Server) getAuditRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	if s.audit == nil {
+		sendError(w, http.StatusServiceUnavailable, "audit is disabled")
+		return
+	}
+
+	// Журнал всегда ограничен арендатором запроса. Записи удалённого арендатора с тем же ID
+	// отсекает incarnation
+	filter := models.AuditFilter{
+		Tenant:            tenantFromContext(r.Context()),
+		TenantIncarnation: incarnationFromContext(r.Context()),
+	}
+	query := r.URL.Query()
+
+	if eventID := query.Get("event_id"); eventID != "" {
+		id, err := strconv.Atoi(eventID)
+		if err != nil || id <= 0 {
+			sendError(w, http.StatusBadRequest, ErrBadID.Error())
+			return
+		}
+		filter.EventID = id
+	}
+
+	filter.Actor = query.Get("user")
+
+	var err error
+	if filter.From, err = parseAuditTime(query.Get("from")); err != nil {
+		sendError(w, http.StatusBadRequest, "from is bad")
+		return
+	}
+	if filter.To, err = parseAuditTime(query.Get("to")); err != nil {
+		sendError(w, http.StatusBadRequest, "to is bad")
+		return
+	}
+
+	records, err := s.audit.Find(filter)
+	if err != nil {
+		sendError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	sendResponse(w, http.StatusOK, convertAuditRecords(records))
+}
+
+// Границы интервала принимаются в RFC3339 или в виде даты 2006-01-02. Пустое значение - без ограничения
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -3,7 +3,9 @@ package server
 import (
 	"calendar-server/models"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,6 +17,7 @@ var (
 	ErrBadName   error = fmt.Errorf("name is bad")
 	ErrBadDate   error = fmt.Errorf("date is bad")
 	ErrBadJson   error = fmt.Errorf("json is not parsed")
+	ErrTooLarge  error = fmt.Errorf("request body is too large")
 )
 
 type Event struct {
@@ -59,7 +62,7 @@ func (s *Server) getEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	event, err := s.events.GetEvent(eventID)
+	event, err := s.eventService(r).GetEvent(eventID)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
@@ -94,9 +97,8 @@ func (s *Server) AddEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req AddEventRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, ErrBadJson.Error())
+	if err := decodeJSONBody(w, r, &req, s.maxBodyBytes); err != nil {
+		sendDecodeError(w, err)
 		return
 	}
 	if err := req.isValid(); err != nil {
@@ -106,15 +108,21 @@ func (s *Server) AddEvent(w http.ResponseWriter, r *http.Request) {
 
 	data := convertAddEventRequest(req)
 
-	ID, err := s.events.AddEvent(data)
+	created, err := s.eventService(r).AddEvent(data)
+	if errors.Is(err, models.ErrQuotaExceeded) {
+		sendError(w, http.StatusForbidden, models.ErrQuotaExceeded.Error())
+		return
+	}
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
 	}
 
+	s.writeAudit(r, models.AuditCreate, created.ID, nil, &created)
+
 	sendResponse(w, http.StatusOK, struct {
 		ID int `json:"id"`
-	}{ID: ID})
+	}{ID: created.ID})
 }
 
 func convertAddEventRequest(req AddEventRequest) models.NewEventData {
@@ -158,9 +166,8 @@ func (s *Server) UpdateEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateEventRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, ErrBadJson.Error())
+	if err := decodeJSONBody(w, r, &req, s.maxBodyBytes); err != nil {
+		sendDecodeError(w, err)
 		return
 	}
 	if err := req.isValid(); err != nil {
@@ -170,11 +177,13 @@ func (s *Server) UpdateEvent(w http.ResponseWriter, r *http.Request) {
 
 	data := convertUpdateEventRequest(req)
 
-	updated, err := s.events.UpdateEvent(data)
+	before, updated, err := s.eventService(r).UpdateEvent(data)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
 	}
+	s.writeAudit(r, models.AuditUpdate, updated.ID, &before, &updated)
+
 	sendResponse(w, http.StatusOK, convertEvent(updated))
 }
 
@@ -216,9 +225,8 @@ func (s *Server) DeleteEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req DataToDeleteEvent
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, ErrBadJson.Error())
+	if err := decodeJSONBody(w, r, &req, s.maxBodyBytes); err != nil {
+		sendDecodeError(w, err)
 		return
 	}
 	if err := req.isValid(); err != nil {
@@ -226,11 +234,13 @@ func (s *Server) DeleteEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleted, err := s.events.DeleteEvent(req.ID)
+	deleted, err := s.eventService(r).DeleteEvent(req.ID)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
 	}
+	s.writeAudit(r, models.AuditDelete, deleted.ID, &deleted, nil)
+
 	sendResponse(w, http.StatusOK, convertEvent(deleted))
 }
 
@@ -251,7 +261,7 @@ func (s *Server) getEventsForDay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := s.events.FindByDay(day)
+	events, err := s.eventService(r).FindByDay(day)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
@@ -277,7 +287,7 @@ func (s *Server) getEventsForWeek(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := s.events.FindByWeek(week)
+	events, err := s.eventService(r).FindByWeek(week)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
@@ -303,7 +313,7 @@ func (s *Server) getEventsForMonth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := s.events.FindByMonth(week)
+	events, err := s.eventService(r).FindByMonth(week)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
@@ -329,7 +339,7 @@ func (s *Server) getEventsForYear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := s.events.FindByYear(year)
+	events, err := s.eventService(r).FindByYear(year)
 	if err != nil {
 		sendError(w, http.StatusServiceUnavailable, err.Error())
 		return
@@ -338,6 +348,37 @@ func (s *Server) getEventsForYear(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, http.StatusOK, convertEvents(events))
 }
 
+// decodeJSONBody строго разбирает тело запроса: размер ограничен, неизвестные поля и данные после объекта не допускаются
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, limit int64) error {
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+	if err == nil {
+		// после объекта в теле ничего не должно быть
+		if _, err = decoder.Token(); errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrTooLarge
+	}
+	return ErrBadJson
+}
+
+func sendDecodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTooLarge) {
+		sendError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	sendError(w, http.StatusBadRequest, err.Error())
+}
+
 func sendResponse(w http.ResponseWriter, code int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(code)
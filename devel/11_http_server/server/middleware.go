@@ -1,11 +1,26 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"time"
 )
 
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	tenantKey
+	incarnationKey
+	eventsKey
+	adminKey
+)
+
+const requestIDHeader = "X-Request-ID"
+
 func logMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nw := time.Now()
@@ -13,3 +28,31 @@ func logMiddleware(handler http.Handler) http.Handler {
 		fmt.Printf("%s %s %s %s\n", r.RemoteAddr, r.Method, r.URL, time.Since(nw))
 	})
 }
+
+// requestIDMiddleware берёт идентификатор запроса из заголовка X-Request-ID или генерирует новый,
+// кладёт его в контекст запроса и возвращает клиенту в ответе
+func requestIDMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
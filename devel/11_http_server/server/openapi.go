@@ -0,0 +1,22 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// Спецификация API. Соответствие реальным ответам обработчиков проверяется в openapi_test.go
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *Server) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
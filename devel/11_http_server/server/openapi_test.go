@@ -0,0 +1,317 @@
+package server
+
+import (
+	auditstorage "calendar-server/auditStorage"
+	"calendar-server/config"
+	eventstorage "calendar-server/eventStorage"
+	"calendar-server/filedb"
+	"calendar-server/models"
+	tenantstorage "calendar-server/tenantStorage"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schema = map[string]interface{}
+
+func loadSpec(t *testing.T) schema {
+	var spec schema
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json invalid: %s", err.Error())
+	}
+	return spec
+}
+
+// resolve раскрывает ссылки вида #/components/schemas/Name
+func resolve(spec schema, node schema) schema {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		cur := interface{}(spec)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			cur = cur.(schema)[part]
+		}
+		node = cur.(schema)
+	}
+}
+
+// validate проверяет значение на соответствие подмножеству JSON Schema, которое используется в спецификации
+func validate(spec schema, s schema, value interface{}, path string) []string {
+	s = resolve(spec, s)
+	var errs []string
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not in enum %v", path, value, enum))
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected object, got %T", path, value))
+		}
+		props, _ := s["properties"].(schema)
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := obj[r.(string)]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: missing required %q", path, r))
+				}
+			}
+		}
+		for k, v := range obj {
+			propSchema, ok := props[k].(schema)
+			if !ok {
+				if s["additionalProperties"] == false {
+					errs = append(errs, fmt.Sprintf("%s: unexpected property %q", path, k))
+				}
+				continue
+			}
+			errs = append(errs, validate(spec, propSchema, v, path+"."+k)...)
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected array, got %T", path, value))
+		}
+		items := s["items"].(schema)
+		for i, v := range arr {
+			errs = append(errs, validate(spec, items, v, path+"["+strconv.Itoa(i)+"]")...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected string, got %T", path, value))
+		}
+		switch s["format"] {
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date", path, str))
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date-time", path, str))
+			}
+		}
+	case "integer":
+		num, ok := value.(float64)
+		if !ok || num != math.Trunc(num) {
+			errs = append(errs, fmt.Sprintf("%s: expected integer, got %v", path, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected number, got %T", path, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected boolean, got %T", path, value))
+		}
+	}
+
+	return errs
+}
+
+func responseSchema(t *testing.T, spec schema, path, method string, code int) schema {
+	op, ok := spec["paths"].(schema)[path].(schema)[strings.ToLower(method)].(schema)
+	if !ok {
+		t.Fatalf("spec has no operation %s %s", method, path)
+	}
+	resp, ok := op["responses"].(schema)[strconv.Itoa(code)].(schema)
+	if !ok {
+		t.Fatalf("spec has no response %d for %s %s", code, method, path)
+	}
+	resp = resolve(spec, resp)
+	return resp["content"].(schema)["application/json"].(schema)["schema"].(schema)
+}
+
+func Test_openAPI_contract(t *testing.T) {
+	spec := loadSpec(t)
+
+	cfg := config.NewTestConfig()
+	cfg.AdminToken = "secret"
+	// restore сохраняет события в файл, поэтому работаем с копией тестовой БД
+	db, err := filedb.New(copyTestDB(t, cfg.DbFilename))
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+	defer db.Close()
+	es, err := eventstorage.New(db)
+	if err != nil {
+		log.Fatalf("eventstorage: %s", err.Error())
+	}
+	audit, err := auditstorage.New(filepath.Join(t.TempDir(), cfg.AuditFilename))
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+	defer audit.Close()
+
+	cfg.RateLimit = 0
+	handler := New(*cfg, es, audit).server.Handler
+	// init ended
+
+	// Порядок важен: изменения выполняются до запроса журнала, чтобы в нём были записи с before/after
+	checkContract(t, spec, handler, []contractCase{
+		{http.MethodGet, "/event", "id=1", "", nil, http.StatusOK},
+		{http.MethodGet, "/event", "id=x", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/event", "id=100", "", nil, http.StatusServiceUnavailable},
+		{http.MethodPost, "/create_event", "", `{"user_id":1,"name":"new","date":"2024-12-31"}`, nil, http.StatusOK},
+		{http.MethodPost, "/create_event", "", `{"user_id":1}`, nil, http.StatusBadRequest},
+		{http.MethodPut, "/update_event", "", `{"id":2,"name":"renamed"}`, nil, http.StatusOK},
+		{http.MethodDelete, "/delete_event", "", `{"id":3}`, nil, http.StatusOK},
+		{http.MethodGet, "/events_for_day", "day=2024-12-30", "", nil, http.StatusOK},
+		{http.MethodGet, "/events_for_day", "day=2000-01-01", "", nil, http.StatusOK},
+		{http.MethodGet, "/events_for_week", "week=2024-12-30", "", nil, http.StatusOK},
+		{http.MethodGet, "/events_for_month", "month=2024-12-01", "", nil, http.StatusOK},
+		{http.MethodGet, "/events_for_year", "year=2024", "", nil, http.StatusOK},
+		{http.MethodGet, "/events_for_year", "year=bad", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/audit", "", "", nil, http.StatusOK},
+		{http.MethodGet, "/audit", "from=bad", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/openapi.json", "", "", nil, http.StatusOK},
+		{http.MethodGet, "/admin/backup", "", "", adminHeaders, http.StatusOK},
+		{http.MethodGet, "/admin/backup", "", "", nil, http.StatusForbidden},
+		{http.MethodPost, "/admin/restore", "", `{"version":2,"events":[{"id":1,"user_id":1,"name":"a","date":"2024-01-01"}]}`, adminHeaders, http.StatusOK},
+		{http.MethodPost, "/admin/restore", "", `{"version":99,"events":[]}`, adminHeaders, http.StatusBadRequest},
+	})
+}
+
+func Test_openAPI_contract_multiTenant(t *testing.T) {
+	spec := loadSpec(t)
+
+	cfg := config.NewTestConfig()
+	cfg.AdminToken = "secret"
+	cfg.RateLimit = 0
+
+	tenants, err := tenantstorage.New(t.TempDir(), 10)
+	if err != nil {
+		log.Fatalf("tenantstorage: %s", err.Error())
+	}
+	defer tenants.Close()
+	audit, err := auditstorage.New(filepath.Join(t.TempDir(), cfg.AuditFilename))
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+	defer audit.Close()
+
+	handler := NewMultiTenant(*cfg, tenantService{tenants}, audit).server.Handler
+	// init ended
+
+	tenant := map[string]string{"X-Admin-Token": "secret", "X-Tenant-ID": "alpha"}
+	checkContract(t, spec, handler, []contractCase{
+		{http.MethodPost, "/admin/create_tenant", "", `{"id":"alpha","max_events":5}`, adminHeaders, http.StatusOK},
+		{http.MethodPost, "/admin/create_tenant", "", `{"id":"alpha"}`, adminHeaders, http.StatusBadRequest},
+		{http.MethodPost, "/admin/create_tenant", "", `{"id":"Bad/ID"}`, adminHeaders, http.StatusBadRequest},
+		{http.MethodPost, "/admin/create_tenant", "", `{"id":"beta"}`, nil, http.StatusForbidden},
+		{http.MethodGet, "/admin/tenants", "", "", adminHeaders, http.StatusOK},
+		{http.MethodGet, "/admin/tenants", "", "", nil, http.StatusForbidden},
+		{http.MethodGet, "/event", "id=1", "", nil, http.StatusBadRequest},
+		{http.MethodGet, "/event", "id=1", "", map[string]string{"X-Tenant-ID": "alpha"}, http.StatusUnauthorized},
+		{http.MethodGet, "/event", "id=1", "", map[string]string{"X-Tenant-ID": "gamma"}, http.StatusUnauthorized},
+		{http.MethodGet, "/admin/backup", "", "", map[string]string{"X-Admin-Token": "secret", "X-Tenant-ID": "gamma"}, http.StatusNotFound},
+		{http.MethodGet, "/admin/backup", "", "", tenant, http.StatusOK},
+		{http.MethodPost, "/admin/restore", "", `{"version":2,"events":[]}`, tenant, http.StatusOK},
+		{http.MethodDelete, "/admin/delete_tenant", "", `{"id":"alpha"}`, adminHeaders, http.StatusOK},
+		{http.MethodDelete, "/admin/delete_tenant", "", `{"id":"alpha"}`, adminHeaders, http.StatusNotFound},
+		{http.MethodDelete, "/admin/delete_tenant", "", `{}`, adminHeaders, http.StatusBadRequest},
+		{http.MethodGet, "/admin/tenants", "", "", adminHeaders, http.StatusOK},
+	})
+}
+
+var adminHeaders = map[string]string{"X-Admin-Token": "secret"}
+
+type contractCase struct {
+	method  string
+	path    string
+	query   string
+	body    string
+	headers map[string]string
+	code    int
+}
+
+// checkContract выполняет запросы по порядку и проверяет коды и тела ответов по спецификации
+func checkContract(t *testing.T, spec schema, handler http.Handler, tests []contractCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+"?"+tt.query, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path+"?"+tt.query, strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				request.Header.Set(k, v)
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			checkResponseCode(t, tt.code, response.Code)
+
+			var body interface{}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("JSON invalid: %s", err.Error())
+			}
+			for _, e := range validate(spec, responseSchema(t, spec, tt.path, tt.method, response.Code), body, "$") {
+				t.Error(e)
+			}
+		})
+	}
+}
+
+// Поля схем запросов и ответов должны совпадать с json-тегами соответствующих типов
+func Test_openAPI_schemasMatchTypes(t *testing.T) {
+	spec := loadSpec(t)
+	schemas := spec["components"].(schema)["schemas"].(schema)
+
+	types := map[string]interface{}{
+		"Event":               Event{},
+		"AddEventRequest":     AddEventRequest{},
+		"UpdateEventRequest":  UpdateEventRequest{},
+		"DataToDeleteEvent":   DataToDeleteEvent{},
+		"AuditRecord":         AuditRecord{},
+		"AuditChange":         AuditChange{},
+		"EventsSnapshot":      models.EventsSnapshot{},
+		"Tenant":              Tenant{},
+		"CreateTenantRequest": CreateTenantRequest{},
+		"DeleteTenantRequest": DeleteTenantRequest{},
+	}
+	for name, v := range types {
+		t.Run(name, func(t *testing.T) {
+			s, ok := schemas[name].(schema)
+			if !ok {
+				t.Fatalf("spec has no schema %s", name)
+			}
+			var specFields []string
+			for k := range s["properties"].(schema) {
+				specFields = append(specFields, k)
+			}
+			sort.Strings(specFields)
+
+			var typeFields []string
+			rt := reflect.TypeOf(v)
+			for i := 0; i < rt.NumField(); i++ {
+				tag := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+				if tag != "" && tag != "-" {
+					typeFields = append(typeFields, tag)
+				}
+			}
+			sort.Strings(typeFields)
+
+			if !reflect.DeepEqual(specFields, typeFields) {
+				t.Errorf("Expected fields %v. Got %v", typeFields, specFields)
+			}
+		})
+	}
+}
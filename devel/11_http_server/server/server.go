@@ -10,8 +10,10 @@ import (
 
 type EventService interface {
 	GetEvent(ID int) (models.EventData, error)
-	AddEvent(data models.NewEventData) (int, error)
-	UpdateEvent(data models.UpdateEventData) (models.EventData, error)
+	// AddEvent возвращает сохранённое событие
+	AddEvent(data models.NewEventData) (models.EventData, error)
+	// UpdateEvent возвращает событие до и после изменения
+	UpdateEvent(data models.UpdateEventData) (models.EventData, models.EventData, error)
 	DeleteEvent(ID int) (models.EventData, error)
 	FindByDay(day time.Time) ([]models.EventData, error)
 	FindByWeek(week time.Time) ([]models.EventData, error)
@@ -19,32 +21,93 @@ type EventService interface {
 	FindByYear(year int) ([]models.EventData, error)
 }
 
+type AuditService interface {
+	Record(rec models.AuditRecord) (models.AuditRecord, error)
+	Find(filter models.AuditFilter) ([]models.AuditRecord, error)
+}
+
 type Server struct {
-	events EventService
-	server *http.Server
+	// events - хранилище единственного арендатора, tenants - реестр в режиме с несколькими арендаторами.
+	// Задано ровно одно из них
+	events       EventService
+	tenants      TenantService
+	audit        AuditService
+	server       *http.Server
+	limiter      *rateLimiter
+	maxBodyBytes int64
+
+	adminToken       string
+	maxSnapshotBytes int64
+}
+
+// New создаёт сервер с одним общим хранилищем событий
+func New(cfg config.Config, event EventService, audit AuditService) *Server {
+	return newServer(cfg, event, nil, audit)
 }
 
-func New(cfg config.Config, event EventService) *Server {
-	httpServer := &http.Server{Addr: cfg.Port, Handler: http.DefaultServeMux}
+// NewMultiTenant создаёт сервер, в котором события, идентификаторы и журнал разделены по арендаторам
+func NewMultiTenant(cfg config.Config, tenants TenantService, audit AuditService) *Server {
+	return newServer(cfg, nil, tenants, audit)
+}
+
+func newServer(cfg config.Config, event EventService, tenants TenantService, audit AuditService) *Server {
+	// Используем собственный mux, а не http.DefaultServeMux, чтобы можно было создавать несколько серверов (например, в тестах)
+	mux := http.NewServeMux()
+	httpServer := &http.Server{Addr: cfg.Port, Handler: mux}
 
 	s := &Server{
-		events: event,
-		server: httpServer,
+		events:       event,
+		tenants:      tenants,
+		audit:        audit,
+		server:       httpServer,
+		maxBodyBytes: cfg.MaxBodyBytes,
+
+		adminToken:       cfg.AdminToken,
+		maxSnapshotBytes: cfg.MaxSnapshotBytes,
 	}
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+
+	mux.Handle("/event", s.middleware(s.withTenant(s.getEvent)))
+	mux.Handle("/create_event", s.middleware(s.withTenant(s.AddEvent)))
+	mux.Handle("/update_event", s.middleware(s.withTenant(s.UpdateEvent)))
+	mux.Handle("/delete_event", s.middleware(s.withTenant(s.DeleteEvent)))
 
-	http.Handle("/event", logMiddleware(http.HandlerFunc(s.getEvent)))
-	http.Handle("/create_event", logMiddleware(http.HandlerFunc(s.AddEvent)))
-	http.Handle("/update_event", logMiddleware(http.HandlerFunc(s.UpdateEvent)))
-	http.Handle("/delete_event", logMiddleware(http.HandlerFunc(s.DeleteEvent)))
+	mux.Handle("/events_for_day", s.middleware(s.withTenant(s.getEventsForDay)))
+	mux.Handle("/events_for_week", s.middleware(s.withTenant(s.getEventsForWeek)))
+	mux.Handle("/events_for_month", s.middleware(s.withTenant(s.getEventsForMonth)))
+	mux.Handle("/events_for_year", s.middleware(s.withTenant(s.getEventsForYear)))
 
-	http.Handle("/events_for_day", logMiddleware(http.HandlerFunc(s.getEventsForDay)))
-	http.Handle("/events_for_week", logMiddleware(http.HandlerFunc(s.getEventsForWeek)))
-	http.Handle("/events_for_month", logMiddleware(http.HandlerFunc(s.getEventsForMonth)))
-	http.Handle("/events_for_year", logMiddleware(http.HandlerFunc(s.getEventsForYear)))
+	mux.Handle("/audit", s.middleware(s.withTenant(s.getAuditRecords)))
+
+	mux.Handle("/openapi.json", s.middleware(s.getOpenAPI))
+
+	mux.Handle("/admin/backup", s.middleware(s.adminMiddleware(s.withTenant(s.getBackup))))
+	mux.Handle("/admin/restore", s.middleware(s.adminMiddleware(s.withTenant(s.restoreBackup))))
+
+	if tenants != nil {
+		mux.Handle("/admin/tenants", s.middleware(s.adminMiddleware(s.getTenants)))
+		mux.Handle("/admin/create_tenant", s.middleware(s.adminMiddleware(s.createTenant)))
+		mux.Handle("/admin/delete_tenant", s.middleware(s.adminMiddleware(s.deleteTenant)))
+	}
 
 	return s
 }
 
+// middleware - общая цепочка обработки для всех эндпоинтов
+func (s *Server) middleware(handler http.HandlerFunc) http.Handler {
+	var h http.Handler = handler
+	if s.limiter != nil {
+		h = s.limiter.middleware(h)
+	}
+	return logMiddleware(requestIDMiddleware(h))
+}
+
+func (s *Server) ListenAndServe() error {
+	return s.server.ListenAndServe()
+}
+
 func (s *Server) Shutdown() error {
 	return s.server.Shutdown(context.Background())
 }
@@ -1,15 +1,21 @@
 package server
 
 import (
+	auditstorage "calendar-server/auditStorage"
 	"calendar-server/config"
 	eventstorage "calendar-server/eventStorage"
 	"calendar-server/filedb"
+	"calendar-server/models"
+	tenantstorage "calendar-server/tenantStorage"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -30,7 +36,7 @@ func Test_application_getEventByID(t *testing.T) {
 	if err != nil {
 		log.Fatalf("eventstorage: %s", err.Error())
 	}
-	server := New(*cfg, es)
+	server := New(*cfg, es, nil)
 	// init ended
 
 	request, _ := http.NewRequest(http.MethodGet, "/event", nil)
@@ -74,7 +80,7 @@ func Test_application_getEventByYear(t *testing.T) {
 	if err != nil {
 		log.Fatalf("eventstorage: %s", err.Error())
 	}
-	server := New(*cfg, es)
+	server := New(*cfg, es, nil)
 	// init ended
 
 	request, _ := http.NewRequest(http.MethodGet, "/events_for_year", nil)
@@ -108,3 +114,420 @@ func Test_application_getEventByYear(t *testing.T) {
 		t.Errorf("Expected %v array. Got %v", eventsExpected, eventsGot.Result)
 	}
 }
+
+func Test_application_auditLog(t *testing.T) {
+	cfg := config.NewTestConfig()
+	db, err := filedb.New(cfg.DbFilename)
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+
+	es, err := eventstorage.New(db)
+	if err != nil {
+		log.Fatalf("eventstorage: %s", err.Error())
+	}
+
+	audit, err := auditstorage.New(filepath.Join(t.TempDir(), cfg.AuditFilename))
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+	defer audit.Close()
+
+	server := New(*cfg, es, audit)
+	handler := server.server.Handler
+	// init ended
+
+	request := httptest.NewRequest(http.MethodPut, "/update_event", strings.NewReader(`{"id":2,"name":"renamed"}`))
+	request.Header.Set("X-User-ID", "alice")
+	request.Header.Set("X-Request-ID", "req-1")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	request = httptest.NewRequest(http.MethodDelete, "/delete_event", strings.NewReader(`{"id":1}`))
+	request.Header.Set("X-User-ID", "bob")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	request = httptest.NewRequest(http.MethodGet, "/audit?event_id=2&user=anonymous", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var got struct {
+		Result []AuditRecord `json:"result"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &got); err != nil {
+		t.Fatalf("JSON invalid: %s", err.Error())
+	}
+	if len(got.Result) != 1 {
+		t.Fatalf("Expected 1 audit record. Got %d", len(got.Result))
+	}
+
+	rec := got.Result[0]
+	// X-User-ID не подтверждён токеном, поэтому автором не считается
+	if rec.Action != "update" || rec.Actor != "anonymous" || rec.ClaimedActor != "alice" || rec.RequestID != "req-1" {
+		t.Errorf("Unexpected audit record %+v", rec)
+	}
+	if rec.Before == nil || rec.Before.Name != "second" || rec.After == nil || rec.After.Name != "renamed" {
+		t.Errorf("Unexpected before/after in %+v", rec)
+	}
+	expectedChanges := []AuditChange{{Field: "name", Old: "second", New: "renamed"}}
+	if !reflect.DeepEqual(expectedChanges, rec.Changes) {
+		t.Errorf("Expected %v changes. Got %v", expectedChanges, rec.Changes)
+	}
+
+	all, err := audit.Find(models.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Find: %s", err.Error())
+	}
+	if len(all) != 2 || all[1].Action != models.AuditDelete || all[1].After != nil {
+		t.Errorf("Expected update and delete records. Got %+v", all)
+	}
+}
+
+func newTestServer(cfg config.Config) *Server {
+	db, err := filedb.New(cfg.DbFilename)
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+
+	es, err := eventstorage.New(db)
+	if err != nil {
+		log.Fatalf("eventstorage: %s", err.Error())
+	}
+	return New(cfg, es, nil)
+}
+
+func Test_application_rateLimit(t *testing.T) {
+	cfg := config.NewTestConfig()
+	cfg.RateLimit = 0.001
+	cfg.RateBurst = 2
+	handler := newTestServer(*cfg).server.Handler
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/event?id=1", nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		codes = append(codes, response.Code)
+
+		if response.Code == http.StatusTooManyRequests && response.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected Retry-After header on 429")
+		}
+	}
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	if !reflect.DeepEqual(expected, codes) {
+		t.Errorf("Expected codes %v. Got %v", expected, codes)
+	}
+
+	// Смена X-User-ID не даёт новой корзины
+	request := httptest.NewRequest(http.MethodGet, "/event?id=1", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-User-ID", "someone-else")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusTooManyRequests, response.Code)
+
+	// Другой клиент ограничивается отдельно
+	request = httptest.NewRequest(http.MethodGet, "/event?id=1", nil)
+	request.RemoteAddr = "10.0.0.2:1234"
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func Test_application_strictBody(t *testing.T) {
+	cfg := config.NewTestConfig()
+	cfg.MaxBodyBytes = 64
+	handler := newTestServer(*cfg).server.Handler
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "valid", body: `{"user_id":1,"name":"n","date":"2024-01-01"}`, code: http.StatusOK},
+		{name: "unknown field", body: `{"user_id":1,"name":"n","date":"2024-01-01","x":1}`, code: http.StatusBadRequest},
+		{name: "trailing data", body: `{"user_id":1,"name":"n","date":"2024-01-01"}{}`, code: http.StatusBadRequest},
+		{name: "too large", body: `{"user_id":1,"name":"` + strings.Repeat("n", 100) + `","date":"2024-01-01"}`, code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/create_event", strings.NewReader(tt.body))
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			checkResponseCode(t, tt.code, response.Code)
+		})
+	}
+}
+
+// copyTestDB копирует тестовую БД во временный каталог для тестов, которые сохраняют события в файл
+func copyTestDB(t *testing.T, filename string) string {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err.Error())
+	}
+	path := filepath.Join(t.TempDir(), filename)
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatalf("WriteFile: %s", err.Error())
+	}
+	return path
+}
+
+func Test_application_backupRestore(t *testing.T) {
+	cfg := config.NewTestConfig()
+	cfg.AdminToken = "secret"
+	dbFilename := copyTestDB(t, cfg.DbFilename)
+	db, err := filedb.New(dbFilename)
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+	defer db.Close()
+
+	es, err := eventstorage.New(db)
+	if err != nil {
+		log.Fatalf("eventstorage: %s", err.Error())
+	}
+	audit, err := auditstorage.New(filepath.Join(t.TempDir(), cfg.AuditFilename))
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+	defer audit.Close()
+	handler := New(*cfg, es, audit).server.Handler
+	// init ended
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	request.Header.Set("X-Admin-Token", "secret")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	backup := response.Body.String()
+
+	// После бэкапа удаляем событие и восстанавливаемся из копии
+	if _, err := es.DeleteEvent(1); err != nil {
+		t.Fatalf("DeleteEvent: %s", err.Error())
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader(backup))
+	request.Header.Set("X-Admin-Token", "secret")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	if _, err := es.GetEvent(1); err != nil {
+		t.Errorf("Expected event 1 to be restored: %s", err.Error())
+	}
+
+	// Восстановленные данные сразу записываются в файл в текущей версии схемы
+	var stored models.EventsSnapshot
+	data, err := os.ReadFile(dbFilename)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err.Error())
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("JSON invalid: %s", err.Error())
+	}
+	if stored.Version != models.EventsSchemaVersion || len(stored.Events) != 4 {
+		t.Errorf("Expected %d events in schema version %d. Got %+v", 4, models.EventsSchemaVersion, stored)
+	}
+
+	// Восстановление попадает в журнал аудита
+	records, err := audit.Find(models.AuditFilter{})
+	if err != nil {
+		t.Fatalf("audit Find: %s", err.Error())
+	}
+	expectedChanges := []models.AuditChange{{Field: "events", Old: "3", New: "4"}}
+	if len(records) != 1 || records[0].Action != models.AuditRestore || !reflect.DeepEqual(records[0].Changes, expectedChanges) {
+		t.Errorf("Expected one restore audit record. Got %+v", records)
+	}
+
+	// Снимок больше квоты не принимается
+	es.SetMaxEvents(3)
+	request = httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader(backup))
+	request.Header.Set("X-Admin-Token", "secret")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	// Без токена администратора доступ запрещён
+	request = httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+}
+
+func Test_migrate(t *testing.T) {
+	// test_db.txt хранится в первой версии схемы (массив без обёртки)
+	src, err := filedb.New(copyTestDB(t, "test_db.txt"))
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+	defer src.Close()
+
+	dst, err := filedb.New(filepath.Join(t.TempDir(), "migrated.txt"))
+	if err != nil {
+		log.Fatalf("NewFileDB: %s", err.Error())
+	}
+	defer dst.Close()
+
+	n, err := eventstorage.Migrate(src, dst)
+	if err != nil {
+		t.Fatalf("Migrate: %s", err.Error())
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 migrated events. Got %d", n)
+	}
+
+	srcEvents, _ := src.GetEvents()
+	dstEvents, err := dst.GetEvents()
+	if err != nil {
+		t.Fatalf("GetEvents: %s", err.Error())
+	}
+	if !reflect.DeepEqual(srcEvents, dstEvents) {
+		t.Errorf("Expected %v events. Got %v", srcEvents, dstEvents)
+	}
+}
+
+// tenantService адаптирует TenantStorage к интерфейсу TenantService
+type tenantService struct {
+	*tenantstorage.TenantStorage
+}
+
+func (ts tenantService) Events(tenantID string) (EventService, string, func(), error) {
+	events, incarnation, release, err := ts.TenantStorage.Events(tenantID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return events, incarnation, release, nil
+}
+
+func Test_application_multiTenant(t *testing.T) {
+	spec := loadSpec(t)
+
+	cfg := config.NewTestConfig()
+	cfg.AdminToken = "secret"
+	cfg.RateLimit = 0
+
+	tenants, err := tenantstorage.New(t.TempDir(), 10)
+	if err != nil {
+		log.Fatalf("tenantstorage: %s", err.Error())
+	}
+	defer tenants.Close()
+
+	audit, err := auditstorage.New(filepath.Join(t.TempDir(), cfg.AuditFilename))
+	if err != nil {
+		log.Fatalf("auditstorage: %s", err.Error())
+	}
+	defer audit.Close()
+
+	handler := NewMultiTenant(*cfg, tenantService{tenants}, audit).server.Handler
+	// init ended
+
+	do := func(method, path, body string, headers map[string]string) (int, map[string]interface{}) {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			request.Header.Set(k, v)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		var got map[string]interface{}
+		if err := json.Unmarshal(response.Body.Bytes(), &got); err != nil {
+			t.Fatalf("JSON invalid: %s", err.Error())
+		}
+		for _, e := range validate(spec, responseSchema(t, spec, strings.Split(path, "?")[0], method, response.Code), got, "$") {
+			t.Error(e)
+		}
+		return response.Code, got
+	}
+	admin := map[string]string{"X-Admin-Token": "secret"}
+
+	code, created := do(http.MethodPost, "/admin/create_tenant", `{"id":"alpha"}`, admin)
+	checkResponseCode(t, http.StatusOK, code)
+	alphaToken := created["result"].(map[string]interface{})["token"].(string)
+
+	code, created = do(http.MethodPost, "/admin/create_tenant", `{"id":"beta","max_events":1}`, admin)
+	checkResponseCode(t, http.StatusOK, code)
+	betaToken := created["result"].(map[string]interface{})["token"].(string)
+
+	code, _ = do(http.MethodPost, "/admin/create_tenant", `{"id":"../etc"}`, admin)
+	checkResponseCode(t, http.StatusBadRequest, code)
+
+	// У каждого арендатора своя последовательность идентификаторов
+	alpha := map[string]string{"Authorization": "Bearer " + alphaToken}
+	beta := map[string]string{"Authorization": "Bearer " + betaToken}
+	for _, headers := range []map[string]string{alpha, beta} {
+		code, got := do(http.MethodPost, "/create_event", `{"user_id":1,"name":"n","date":"2024-01-01"}`, headers)
+		checkResponseCode(t, http.StatusOK, code)
+		if id := got["result"].(map[string]interface{})["id"]; id != float64(1) {
+			t.Errorf("Expected id 1. Got %v", id)
+		}
+	}
+
+	// Квота арендатора beta - одно событие
+	code, _ = do(http.MethodPost, "/create_event", `{"user_id":1,"name":"n","date":"2024-01-01"}`, beta)
+	checkResponseCode(t, http.StatusForbidden, code)
+
+	code, _ = do(http.MethodGet, "/event?id=1", "", nil)
+	checkResponseCode(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodGet, "/admin/backup", "", map[string]string{"X-Tenant-ID": "gamma", "X-Admin-Token": "secret"})
+	checkResponseCode(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodGet, "/event?id=1", "", map[string]string{"Authorization": "Bearer bad"})
+	checkResponseCode(t, http.StatusUnauthorized, code)
+
+	// Одного заголовка X-Tenant-ID недостаточно, если запрос не от администратора
+	code, _ = do(http.MethodGet, "/event?id=1", "", map[string]string{"X-Tenant-ID": "beta"})
+	checkResponseCode(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/event?id=1", "", map[string]string{"X-Tenant-ID": "gamma"})
+	checkResponseCode(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/event?id=1", "", map[string]string{"X-Tenant-ID": "beta", "Authorization": "Bearer " + alphaToken})
+	checkResponseCode(t, http.StatusOK, code)
+	code, got := do(http.MethodPost, "/admin/restore", `{"version":2,"events":[]}`, map[string]string{"X-Tenant-ID": "beta", "X-Admin-Token": "secret"})
+	checkResponseCode(t, http.StatusOK, code)
+	if events := got["result"].(map[string]interface{})["events"]; events != float64(0) {
+		t.Errorf("Expected 0 restored events. Got %v", events)
+	}
+
+	// Журнал арендатора не содержит чужих записей
+	code, got = do(http.MethodGet, "/audit", "", alpha)
+	checkResponseCode(t, http.StatusOK, code)
+	records := got["result"].([]interface{})
+	if len(records) != 1 || records[0].(map[string]interface{})["tenant"] != "alpha" || records[0].(map[string]interface{})["actor"] != "tenant:alpha" {
+		t.Errorf("Expected one alpha audit record. Got %v", records)
+	}
+	code, got = do(http.MethodGet, "/audit", "", beta)
+	checkResponseCode(t, http.StatusOK, code)
+	records = got["result"].([]interface{})
+	if last := records[len(records)-1].(map[string]interface{}); last["action"] != "restore" || last["actor"] != "admin" {
+		t.Errorf("Expected the restore by admin. Got %v", last)
+	}
+
+	code, got = do(http.MethodGet, "/admin/tenants", "", admin)
+	checkResponseCode(t, http.StatusOK, code)
+	if n := len(got["result"].([]interface{})); n != 2 {
+		t.Errorf("Expected 2 tenants. Got %d", n)
+	}
+
+	code, _ = do(http.MethodDelete, "/admin/delete_tenant", `{"id":"beta"}`, admin)
+	checkResponseCode(t, http.StatusOK, code)
+	code, _ = do(http.MethodGet, "/event?id=1", "", beta)
+	checkResponseCode(t, http.StatusUnauthorized, code)
+
+	// Новый арендатор с тем же идентификатором не видит журнал удалённого
+	code, created = do(http.MethodPost, "/admin/create_tenant", `{"id":"beta"}`, admin)
+	checkResponseCode(t, http.StatusOK, code)
+	beta = map[string]string{"Authorization": "Bearer " + created["result"].(map[string]interface{})["token"].(string)}
+	code, got = do(http.MethodGet, "/audit", "", beta)
+	checkResponseCode(t, http.StatusOK, code)
+	if records := got["result"].([]interface{}); len(records) != 0 {
+		t.Errorf("Expected no audit records for the new beta. Got %v", records)
+	}
+	code, got = do(http.MethodGet, "/audit", "", alpha)
+	checkResponseCode(t, http.StatusOK, code)
+	if records := got["result"].([]interface{}); len(records) != 1 {
+		t.Errorf("Expected alpha audit records to stay. Got %v", records)
+	}
+}
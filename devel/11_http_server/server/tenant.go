@@ -0,0 +1,208 @@
+package server
+
+import (
+	"calendar-server/models"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const tenantHeader = "X-Tenant-ID"
+
+var (
+	ErrNoTenant  error = fmt.Errorf("tenant is required")
+	ErrBadTenant error = fmt.Errorf("tenant is bad")
+	ErrBadToken  error = fmt.Errorf("token is bad")
+	ErrNoToken   error = fmt.Errorf("token is required")
+)
+
+// TenantService - реестр арендаторов. У каждого арендатора собственное хранилище событий
+type TenantService interface {
+	// Events возвращает хранилище событий арендатора и его incarnation, которым помечаются записи журнала.
+	// release вызывается по окончании запроса: до этого Delete не закрывает хранилище
+	Events(tenantID string) (events EventService, incarnation string, release func(), err error)
+	TenantByToken(token string) (string, error)
+	Create(data models.NewTenantData) (models.TenantData, string, error)
+	List() ([]models.TenantData, error)
+	Delete(tenantID string) (models.TenantData, error)
+}
+
+type Tenant struct {
+	ID        string    `json:"id"`
+	MaxEvents int       `json:"max_events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func convertTenant(data models.TenantData) Tenant {
+	return Tenant{
+		ID:        data.ID,
+		MaxEvents: data.MaxEvents,
+		CreatedAt: data.CreatedAt,
+	}
+}
+
+func convertTenants(datas []models.TenantData) []Tenant {
+	res := make([]Tenant, 0, len(datas))
+	for _, d := range datas {
+		res = append(res, convertTenant(d))
+	}
+	return res
+}
+
+// withTenant определяет арендатора запроса и кладёт в контекст его хранилище событий.
+// Арендатор берётся из токена (Authorization: Bearer ...) или из заголовка X-Tenant-ID.
+// Заголовок без токена принимается только в запросах администратора.
+// В режиме с одним арендатором используется общее хранилище сервера
+func (s *Server) withTenant(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.tenants == nil {
+			handler(w, r)
+			return
+		}
+
+		var tenantID string
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			token, ok := strings.CutPrefix(auth, "Bearer ")
+			if !ok {
+				sendError(w, http.StatusUnauthorized, ErrBadToken.Error())
+				return
+			}
+			id, err := s.tenants.TenantByToken(token)
+			if err != nil {
+				sendError(w, http.StatusUnauthorized, ErrBadToken.Error())
+				return
+			}
+			tenantID = id
+		} else {
+			tenantID = r.Header.Get(tenantHeader)
+			if tenantID != "" && !isAdmin(r) {
+				sendError(w, http.StatusUnauthorized, ErrNoToken.Error())
+				return
+			}
+		}
+		if tenantID == "" {
+			sendError(w, http.StatusBadRequest, ErrNoTenant.Error())
+			return
+		}
+
+		events, incarnation, release, err := s.tenants.Events(tenantID)
+		if err != nil {
+			sendError(w, http.StatusNotFound, ErrBadTenant.Error())
+			return
+		}
+		defer release()
+
+		ctx := context.WithValue(r.Context(), tenantKey, tenantID)
+		ctx = context.WithValue(ctx, incarnationKey, incarnation)
+		ctx = context.WithValue(ctx, eventsKey, events)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// eventService возвращает хранилище событий арендатора, определённого в withTenant.
+// Без арендатора в контексте используется общее хранилище сервера
+func (s *Server) eventService(r *http.Request) EventService {
+	if events, ok := r.Context().Value(eventsKey).(EventService); ok {
+		return events
+	}
+	return s.events
+}
+
+func tenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantKey).(string)
+	return tenantID
+}
+
+func incarnationFromContext(ctx context.Context) string {
+	incarnation, _ := ctx.Value(incarnationKey).(string)
+	return incarnation
+}
+
+func (s *Server) getTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	tenants, err := s.tenants.List()
+	if err != nil {
+		sendError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	sendResponse(w, http.StatusOK, convertTenants(tenants))
+}
+
+type CreateTenantRequest struct {
+	ID        string `json:"id"`
+	MaxEvents int    `json:"max_events"`
+}
+
+func (d CreateTenantRequest) isValid() error {
+	if d.ID == "" {
+		return ErrBadTenant
+	}
+	if d.MaxEvents < 0 {
+		return fmt.Errorf("max_events is bad")
+	}
+	return nil
+}
+
+func (s *Server) createTenant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req CreateTenantRequest
+	if err := decodeJSONBody(w, r, &req, s.maxBodyBytes); err != nil {
+		sendDecodeError(w, err)
+		return
+	}
+	if err := req.isValid(); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, token, err := s.tenants.Create(models.NewTenantData{ID: req.ID, MaxEvents: req.MaxEvents})
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sendResponse(w, http.StatusOK, struct {
+		Tenant
+		Token string `json:"token"`
+	}{Tenant: convertTenant(created), Token: token})
+}
+
+type DeleteTenantRequest struct {
+	ID string `json:"id"`
+}
+
+func (s *Server) deleteTenant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req DeleteTenantRequest
+	if err := decodeJSONBody(w, r, &req, s.maxBodyBytes); err != nil {
+		sendDecodeError(w, err)
+		return
+	}
+	if req.ID == "" {
+		sendError(w, http.StatusBadRequest, ErrBadTenant.Error())
+		return
+	}
+
+	deleted, err := s.tenants.Delete(req.ID)
+	if err != nil {
+		sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	sendResponse(w, http.StatusOK, convertTenant(deleted))
+}
@@ -0,0 +1,309 @@
+package tenantstorage
+
+import (
+	eventstorage "calendar-server/eventStorage"
+	"calendar-server/filedb"
+	"calendar-server/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const registryFilename = "tenants.json"
+
+var (
+	ErrNoTenant     = errors.New("no such tenant")
+	ErrTenantExists = errors.New("tenant already exists")
+	ErrBadTenantID  = errors.New("tenant id is bad")
+	// ErrTenantDeleting возвращается Create, пока удаление арендатора с тем же ID не завершено
+	ErrTenantDeleting = errors.New("tenant is being deleted")
+)
+
+// Идентификатор арендатора используется в имени файла, поэтому допускаются только безопасные символы
+var tenantIDRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenant struct {
+	data   models.TenantData
+	db     *filedb.FileDB
+	events *eventstorage.EventStorage
+	// запросы, которые сейчас работают с events: Delete дожидается их перед закрытием db
+	inUse sync.WaitGroup
+}
+
+// TenantStorage хранит реестр арендаторов и отдельное хранилище событий для каждого из них.
+// События арендатора лежат в файле <dir>/<id>.txt, реестр - в <dir>/tenants.json
+type TenantStorage struct {
+	dir              string
+	defaultMaxEvents int
+	tenants          map[string]*tenant
+	// deleting - арендаторы, которых Delete уже убрал из реестра, но ещё не закрыл
+	deleting map[string]struct{}
+	rwm      sync.RWMutex
+}
+
+func New(dir string, defaultMaxEvents int) (*TenantStorage, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("tenantstorage New: %w", err)
+	}
+
+	ts := &TenantStorage{
+		dir:              dir,
+		defaultMaxEvents: defaultMaxEvents,
+		tenants:          make(map[string]*tenant),
+		deleting:         make(map[string]struct{}),
+	}
+
+	datas, err := ts.readRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("tenantstorage New: %w", err)
+	}
+	for _, data := range datas {
+		t, err := ts.openTenant(data)
+		if err != nil {
+			ts.Close()
+			return nil, fmt.Errorf("tenantstorage New: %w", err)
+		}
+		ts.tenants[data.ID] = t
+	}
+
+	return ts, nil
+}
+
+func (ts *TenantStorage) readRegistry() ([]models.TenantData, error) {
+	data, err := os.ReadFile(filepath.Join(ts.dir, registryFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readRegistry: %w", err)
+	}
+
+	var datas []models.TenantData
+	if err := json.Unmarshal(data, &datas); err != nil {
+		return nil, fmt.Errorf("readRegistry: %w", err)
+	}
+	return datas, nil
+}
+
+// saveRegistry записывает реестр через временный файл, чтобы при сбое не потерять его целиком
+func (ts *TenantStorage) saveRegistry() error {
+	datas := make([]models.TenantData, 0, len(ts.tenants))
+	for _, t := range ts.tenants {
+		datas = append(datas, t.data)
+	}
+	sort.Slice(datas, func(i, j int) bool { return datas[i].ID < datas[j].ID })
+
+	data, err := json.MarshalIndent(datas, "", "    ")
+	if err != nil {
+		return fmt.Errorf("saveRegistry: %w", err)
+	}
+
+	path := filepath.Join(ts.dir, registryFilename)
+	if err := os.WriteFile(path+".tmp", data, 0666); err != nil {
+		return fmt.Errorf("saveRegistry: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("saveRegistry: %w", err)
+	}
+	return nil
+}
+
+func (ts *TenantStorage) eventsFilename(id string) string {
+	return filepath.Join(ts.dir, id+".txt")
+}
+
+func (ts *TenantStorage) openTenant(data models.TenantData) (*tenant, error) {
+	db, err := filedb.New(ts.eventsFilename(data.ID))
+	if err != nil {
+		return nil, fmt.Errorf("openTenant %s: %w", data.ID, err)
+	}
+
+	events, err := eventstorage.New(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("openTenant %s: %w", data.ID, err)
+	}
+	events.SetMaxEvents(data.MaxEvents)
+
+	return &tenant{data: data, db: db, events: events}, nil
+}
+
+// Create регистрирует арендатора и возвращает его токен доступа. Токен показывается только один раз
+func (ts *TenantStorage) Create(data models.NewTenantData) (models.TenantData, string, error) {
+	if !tenantIDRegexp.MatchString(data.ID) {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", ErrBadTenantID)
+	}
+	if data.MaxEvents < 0 {
+		return models.TenantData{}, "", fmt.Errorf("Create: max events must not be negative")
+	}
+	if data.MaxEvents == 0 {
+		data.MaxEvents = ts.defaultMaxEvents
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", err)
+	}
+	incarnation, err := newIncarnation()
+	if err != nil {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", err)
+	}
+
+	ts.rwm.Lock()
+	defer ts.rwm.Unlock()
+
+	if _, ok := ts.tenants[data.ID]; ok {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", ErrTenantExists)
+	}
+	if _, ok := ts.deleting[data.ID]; ok {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", ErrTenantDeleting)
+	}
+
+	t, err := ts.openTenant(models.TenantData{
+		ID:          data.ID,
+		MaxEvents:   data.MaxEvents,
+		TokenHash:   hashToken(token),
+		CreatedAt:   time.Now().UTC(),
+		Incarnation: incarnation,
+	})
+	if err != nil {
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", err)
+	}
+
+	ts.tenants[data.ID] = t
+	if err := ts.saveRegistry(); err != nil {
+		delete(ts.tenants, data.ID)
+		t.db.Close()
+		return models.TenantData{}, "", fmt.Errorf("Create: %w", err)
+	}
+
+	return t.data, token, nil
+}
+
+func (ts *TenantStorage) List() ([]models.TenantData, error) {
+	ts.rwm.RLock()
+	defer ts.rwm.RUnlock()
+
+	datas := make([]models.TenantData, 0, len(ts.tenants))
+	for _, t := range ts.tenants {
+		datas = append(datas, t.data)
+	}
+	sort.Slice(datas, func(i, j int) bool { return datas[i].ID < datas[j].ID })
+
+	return datas, nil
+}
+
+// Delete удаляет арендатора вместе с файлом его событий. Новые запросы к арендатору
+// сразу получают ErrNoTenant, а начатые Delete дожидается, прежде чем закрыть файл.
+// До конца удаления Create с тем же ID возвращает ErrTenantDeleting
+func (ts *TenantStorage) Delete(id string) (models.TenantData, error) {
+	ts.rwm.Lock()
+	t, ok := ts.tenants[id]
+	if !ok {
+		ts.rwm.Unlock()
+		return models.TenantData{}, fmt.Errorf("Delete: %w", ErrNoTenant)
+	}
+
+	delete(ts.tenants, id)
+	if err := ts.saveRegistry(); err != nil {
+		ts.tenants[id] = t
+		ts.rwm.Unlock()
+		return models.TenantData{}, fmt.Errorf("Delete: %w", err)
+	}
+	ts.deleting[id] = struct{}{}
+	ts.rwm.Unlock()
+
+	defer func() {
+		ts.rwm.Lock()
+		delete(ts.deleting, id)
+		ts.rwm.Unlock()
+	}()
+
+	// Ждём без блокировки реестра, чтобы не задерживать запросы других арендаторов
+	t.inUse.Wait()
+	if err := t.db.Close(); err != nil {
+		return models.TenantData{}, fmt.Errorf("Delete: %w", err)
+	}
+	if err := os.Remove(ts.eventsFilename(id)); err != nil {
+		return models.TenantData{}, fmt.Errorf("Delete: %w", err)
+	}
+
+	return t.data, nil
+}
+
+// Events возвращает хранилище событий арендатора и его Incarnation. Пока не вызвана release,
+// Delete не закроет хранилище
+func (ts *TenantStorage) Events(id string) (events *eventstorage.EventStorage, incarnation string, release func(), err error) {
+	ts.rwm.RLock()
+	defer ts.rwm.RUnlock()
+
+	t, ok := ts.tenants[id]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("Events: %w", ErrNoTenant)
+	}
+	t.inUse.Add(1)
+	return t.events, t.data.Incarnation, t.inUse.Done, nil
+}
+
+// TenantByToken находит арендатора по токену доступа
+func (ts *TenantStorage) TenantByToken(token string) (string, error) {
+	hash := hashToken(token)
+
+	ts.rwm.RLock()
+	defer ts.rwm.RUnlock()
+
+	for id, t := range ts.tenants {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.data.TokenHash)) == 1 {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("TenantByToken: %w", ErrNoTenant)
+}
+
+// Close сохраняет события всех арендаторов и закрывает их файлы
+func (ts *TenantStorage) Close() error {
+	ts.rwm.Lock()
+	defer ts.rwm.Unlock()
+
+	var errs []error
+	for id, t := range ts.tenants {
+		if err := t.events.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+		if err := t.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newIncarnation() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
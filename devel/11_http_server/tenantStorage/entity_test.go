@@ -0,0 +1,67 @@
+package tenantstorage
+
+import (
+	"calendar-server/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDelete_waitsForRelease(t *testing.T) {
+	ts, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	first, _, err := ts.Create(models.NewTenantData{ID: "alpha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _, release, err := ts.Events("alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := make(chan error)
+	go func() {
+		_, err := ts.Delete("alpha")
+		deleted <- err
+	}()
+
+	// Пока запрос не завершён, хранилище арендатора не закрыто, а новые запросы уже не проходят
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-deleted:
+		t.Fatalf("Delete returned before release: %v", err)
+	default:
+	}
+	if _, err := events.AddEvent(models.NewEventData{UserID: 1, Name: "n", Date: "2024-01-01"}); err != nil {
+		t.Errorf("AddEvent during Delete: %s", err)
+	}
+	if _, _, _, err := ts.Events("alpha"); err == nil {
+		t.Error("Events returned a tenant that is being deleted")
+	}
+	if _, _, err := ts.Create(models.NewTenantData{ID: "alpha"}); !errors.Is(err, ErrTenantDeleting) {
+		t.Errorf("Create during Delete: got %v, want %v", err, ErrTenantDeleting)
+	}
+
+	release()
+	select {
+	case err := <-deleted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Delete did not return after release")
+	}
+
+	// Арендатор с тем же ID создаётся заново только после удаления и получает новый incarnation
+	second, _, err := ts.Create(models.NewTenantData{ID: "alpha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Incarnation == "" || second.Incarnation == first.Incarnation {
+		t.Errorf("incarnation was not renewed: %q, %q", first.Incarnation, second.Incarnation)
+	}
+}